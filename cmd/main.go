@@ -20,6 +20,11 @@ func main() {
 	rootCmd.AddCommand(commands.ExtractCmd)
 	rootCmd.AddCommand(commands.StoreCmd)
 	rootCmd.AddCommand(commands.MountCmd)
+	rootCmd.AddCommand(commands.BenchCmd)
+	rootCmd.AddCommand(commands.InspectCmd)
+	rootCmd.AddCommand(commands.LsCmd)
+	rootCmd.AddCommand(commands.ServeCmd)
+	rootCmd.AddCommand(commands.DiffCmd)
 
 	// Setup signal catching
 	sigs := make(chan os.Signal, 1)
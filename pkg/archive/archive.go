@@ -8,6 +8,7 @@ import (
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"hash/crc64"
 	"io"
 	"io/fs"
@@ -31,7 +32,7 @@ func init() {
 	gob.Register(&common.ClipNode{})
 	gob.Register(&common.StorageInfoWrapper{})
 	gob.Register(&common.S3StorageInfo{})
-
+	gob.Register(&common.HTTPStorageInfo{})
 }
 
 type ClipArchiverOptions struct {
@@ -41,6 +42,27 @@ type ClipArchiverOptions struct {
 	SourcePath  string
 	OutputFile  string
 	OutputPath  string
+
+	// Files under these paths (relative to SourcePath) are written to the front of the archive,
+	// so they sit close together and are read with better locality. Nil uses
+	// DefaultPriorityPaths, an empty slice means no reordering.
+	PriorityPaths []string
+
+	// Also write a node table, so GetNode can look up single nodes without decoding the whole
+	// index. The table holds a second copy of every node, roughly doubling the metadata size.
+	NodeTable bool
+}
+
+const indexReadBufferSize = 1 << 20 // 1Mb
+
+// DefaultPriorityPaths are the paths commonly read first when starting a Beam container image
+var DefaultPriorityPaths = []string{
+	"/rootfs/usr/lib",
+	"/rootfs/usr/bin",
+	"/rootfs/usr/local/lib/python3.7/dist-packages",
+	"/rootfs/usr/local/lib/python3.8/dist-packages",
+	"/rootfs/usr/local/lib/python3.9/dist-packages",
+	"/rootfs/usr/local/lib/python3.10/dist-packages",
 }
 
 type ClipArchiver struct {
@@ -112,13 +134,10 @@ func (ca *ClipArchiver) populateIndex(index *btree.BTree, sourcePath string) err
 
 			var contentHash = ""
 			if nodeType == common.FileNode {
-				fileContent, err := os.ReadFile(path)
+				contentHash, err = hashFile(path)
 				if err != nil {
 					return fmt.Errorf("failed to read file contents for hashing: %w", err)
 				}
-
-				hash := sha256.Sum256(fileContent)
-				contentHash = hex.EncodeToString(hash[:])
 			}
 
 			// Determine the file mode and type
@@ -161,8 +180,13 @@ func (ca *ClipArchiver) populateIndex(index *btree.BTree, sourcePath string) err
 				},
 			}
 
+			xattrs, err := readXattrs(path)
+			if err != nil {
+				return fmt.Errorf("error reading xattrs %s: %v", path, err)
+			}
+
 			pathWithPrefix := filepath.Join("/", strings.TrimPrefix(path, sourcePath))
-			index.Set(&common.ClipNode{Path: pathWithPrefix, NodeType: nodeType, Attr: attr, Target: target, ContentHash: contentHash})
+			index.Set(&common.ClipNode{Path: pathWithPrefix, NodeType: nodeType, Attr: attr, Target: target, ContentHash: contentHash, Xattrs: xattrs})
 
 			return nil
 		},
@@ -172,6 +196,22 @@ func (ca *ClipArchiver) populateIndex(index *btree.BTree, sourcePath string) err
 	return err
 }
 
+// hashFile computes the sha256 of a file's contents without loading it into memory
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func (ca *ClipArchiver) Create(opts ClipArchiverOptions) error {
 	outFile, err := os.Create(opts.OutputFile)
 	if err != nil {
@@ -235,6 +275,17 @@ func (ca *ClipArchiver) Create(opts ClipArchiverOptions) error {
 	header.IndexLength = int64(len(indexBytes))
 	header.IndexPos = indexPos
 
+	if opts.NodeTable {
+		if err := ca.writeNodeTable(outFile, index, &header); err != nil {
+			return err
+		}
+	}
+
+	header.MetadataChecksum, err = ca.computeMetadataChecksum(&header, indexBytes, nil)
+	if err != nil {
+		return err
+	}
+
 	headerBytes, err := ca.EncodeHeader(&header)
 	if err != nil {
 		return err
@@ -324,12 +375,24 @@ func (ca *ClipArchiver) CreateRemoteArchive(storageInfo common.ClipStorageInfo,
 
 	wrapperBytes := buf.Bytes()
 
-	// Write storage info at the end of the file
+	// Write storage info after the index
 	header.StorageInfoLength = int64(len(wrapperBytes))
 	if _, err := outFile.Write(wrapperBytes); err != nil {
 		return err
 	}
 
+	// Keep the node table if the archive being made remote has one
+	if metadata.Header.NodeTableLength > 0 {
+		if err := ca.writeNodeTable(outFile, metadata.Index, &header); err != nil {
+			return err
+		}
+	}
+
+	header.MetadataChecksum, err = ca.computeMetadataChecksum(&header, indexBytes, wrapperBytes)
+	if err != nil {
+		return err
+	}
+
 	// Finally, encode and write the header
 	headerBytes, err := ca.EncodeHeader(&header)
 	if err != nil {
@@ -355,61 +418,90 @@ func (ca *ClipArchiver) ExtractMetadata(archivePath string) (*common.ClipArchive
 	}
 	defer file.Close()
 
-	// Read and decode the header
-	headerBytes := make([]byte, common.ClipHeaderLength)
-	if _, err := io.ReadFull(file, headerBytes); err != nil {
-		return nil, common.ErrFileHeaderMismatch
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
 	}
 
-	// Decode the header
-	header, err := ca.DecodeHeader(headerBytes)
+	return ca.ExtractMetadataFrom(file, fi.Size())
+}
+
+// ExtractMetadataFrom decodes the header, index and storage info of an archive
+// from any io.ReaderAt, such as an in-memory buffer or a ranged remote reader
+func (ca *ClipArchiver) ExtractMetadataFrom(r io.ReaderAt, size int64) (*common.ClipArchiveMetadata, error) {
+	header, err := ca.readHeader(r, size)
 	if err != nil {
-		return nil, common.ErrFileHeaderMismatch
+		return nil, err
 	}
 
-	// Verify the header
-	if !bytes.Equal(header.StartBytes[:], common.ClipFileStartBytes) || header.ClipFileFormatVersion != common.ClipFileFormatVersion {
-		return nil, common.ErrFileHeaderMismatch
+	if header.IndexPos < 0 || header.IndexLength < 0 || header.IndexPos+header.IndexLength > size {
+		return nil, sectionOutOfBounds(header, "index")
 	}
 
-	// Seek to the correct position for the index
-	_, err = file.Seek(header.IndexPos, 0)
-	if err != nil {
-		return nil, fmt.Errorf("error seeking to index: %v", err)
+	// Only version 1 archives may lack a checksum, a zeroed checksum in a later header is corruption
+	if hasMetadataChecksum(header) && header.MetadataChecksum == 0 {
+		return nil, fmt.Errorf("%w: missing metadata checksum", common.ErrMetadataCorrupt)
 	}
 
-	// Read and decode the index
-	indexBytes := make([]byte, header.IndexLength)
-	if _, err := io.ReadFull(file, indexBytes); err != nil {
-		return nil, fmt.Errorf("error reading index: %v", err)
+	// Hash the metadata as it is read, if the archive has a checksum
+	var metadataHash hash.Hash64
+	if hasMetadataChecksum(header) {
+		metadataHash, err = ca.newMetadataHash(header)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	indexReader := bytes.NewReader(indexBytes)
-	indexDec := gob.NewDecoder(indexReader)
+	// Decode the index straight from the reader, so large indexes aren't held in memory twice
+	var indexReader io.Reader = io.NewSectionReader(r, header.IndexPos, header.IndexLength)
+	if metadataHash != nil {
+		indexReader = io.TeeReader(indexReader, metadataHash)
+	}
+	bufferedIndexReader := bufio.NewReaderSize(indexReader, indexReadBufferSize)
 
 	var nodes []*common.ClipNode
-	if err := indexDec.Decode(&nodes); err != nil {
-		return nil, fmt.Errorf("error decoding index: %v", err)
-	}
+	decodeErr := gob.NewDecoder(bufferedIndexReader).Decode(&nodes)
 
-	index := ca.newIndex()
-	for _, node := range nodes {
-		index.Set(node)
+	// Consume whatever the decoder left behind so the checksum covers the whole index
+	if metadataHash != nil {
+		if _, err := io.Copy(io.Discard, bufferedIndexReader); err != nil {
+			return nil, fmt.Errorf("error reading index: %v", err)
+		}
 	}
 
-	var storageInfo common.ClipStorageInfo
+	// Read the storage info
+	var storageBytes []byte
 	if header.StorageInfoLength > 0 {
-		// Read and decode the storage info
-		_, err = file.Seek(header.StorageInfoPos, 0)
-		if err != nil {
-			return nil, fmt.Errorf("error seeking to storage info: %v", err)
+		if header.StorageInfoPos < 0 || header.StorageInfoPos+header.StorageInfoLength > size {
+			return nil, sectionOutOfBounds(header, "storage info")
 		}
 
-		storageBytes := make([]byte, header.StorageInfoLength)
-		if _, err := io.ReadFull(file, storageBytes); err != nil {
+		storageBytes = make([]byte, header.StorageInfoLength)
+		if err := readFullAt(r, storageBytes, header.StorageInfoPos); err != nil {
 			return nil, fmt.Errorf("error reading storage info: %v", err)
 		}
+	}
 
+	// Verify the metadata checksum before trusting anything that was decoded
+	if metadataHash != nil {
+		metadataHash.Write(storageBytes)
+		if metadataHash.Sum64() != header.MetadataChecksum {
+			return nil, common.ErrMetadataCorrupt
+		}
+	}
+
+	if decodeErr != nil {
+		return nil, fmt.Errorf("error decoding index: %v", decodeErr)
+	}
+
+	index := ca.newIndex()
+	for _, node := range nodes {
+		index.Set(node)
+	}
+
+	// Decode the storage info
+	var storageInfo common.ClipStorageInfo
+	if header.StorageInfoLength > 0 {
 		storageReader := bytes.NewReader(storageBytes)
 		storageDec := gob.NewDecoder(storageReader)
 
@@ -418,15 +510,9 @@ func (ca *ClipArchiver) ExtractMetadata(archivePath string) (*common.ClipArchive
 			return nil, fmt.Errorf("error decoding storage info: %v", err)
 		}
 
-		switch wrapper.Type {
-		case "s3":
-			var s3Info common.S3StorageInfo
-			if err := gob.NewDecoder(bytes.NewReader(wrapper.Data)).Decode(&s3Info); err != nil {
-				return nil, fmt.Errorf("error decoding s3 storage info: %v", err)
-			}
-			storageInfo = s3Info
-		default:
-			return nil, fmt.Errorf("unsupported storage info type: %s", wrapper.Type)
+		storageInfo, err = common.DecodeStorageInfo(wrapper)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -437,55 +523,65 @@ func (ca *ClipArchiver) ExtractMetadata(archivePath string) (*common.ClipArchive
 	}, nil
 }
 
-func (ca *ClipArchiver) Extract(opts ClipArchiverOptions) error {
-	file, err := os.Open(opts.ArchivePath)
-	if err != nil {
-		return err
+// sectionOutOfBounds reports a metadata section the header places outside the archive.
+// If the archive has a checksum, the header fields are covered by it, so this is corruption.
+func sectionOutOfBounds(header *common.ClipArchiveHeader, section string) error {
+	if hasMetadataChecksum(header) {
+		return fmt.Errorf("%w: %s out of bounds", common.ErrMetadataCorrupt, section)
+	}
+	return fmt.Errorf("error reading %s: %s out of bounds", section, section)
+}
+
+// hasMetadataChecksum reports whether the header's version carries a metadata checksum
+func hasMetadataChecksum(header *common.ClipArchiveHeader) bool {
+	return header.ClipFileFormatVersion != common.ClipFileFormatVersionV1
+}
+
+// readHeader reads, decodes and verifies the archive header. Both current and
+// version 1 headers are supported.
+func (ca *ClipArchiver) readHeader(r io.ReaderAt, size int64) (*common.ClipArchiveHeader, error) {
+	headerLength := int64(common.ClipHeaderLength)
+	if size < headerLength {
+		headerLength = size
 	}
-	defer file.Close()
-	os.MkdirAll(opts.OutputPath, 0755)
 
-	// Read and decode the header
-	headerBytes := make([]byte, common.ClipHeaderLength)
-	if _, err := io.ReadFull(file, headerBytes); err != nil {
-		return common.ErrFileHeaderMismatch
+	headerBytes := make([]byte, headerLength)
+	if err := readFullAt(r, headerBytes, 0); err != nil {
+		return nil, common.ErrFileHeaderMismatch
 	}
 
 	// Decode the header
 	header, err := ca.DecodeHeader(headerBytes)
 	if err != nil {
-		return common.ErrFileHeaderMismatch
+		return nil, common.ErrFileHeaderMismatch
 	}
 
 	// Verify the header
-	if !bytes.Equal(header.StartBytes[:], common.ClipFileStartBytes) || header.ClipFileFormatVersion != common.ClipFileFormatVersion {
-		return common.ErrFileHeaderMismatch
+	if !bytes.Equal(header.StartBytes[:], common.ClipFileStartBytes) {
+		return nil, common.ErrFileHeaderMismatch
 	}
 
-	// Seek to the correct position for the index
-	_, err = file.Seek(header.IndexPos, 0)
-	if err != nil {
-		return fmt.Errorf("error seeking to index: %v", err)
-	}
+	return header, nil
+}
 
-	// Read and decode the index
-	indexBytes := make([]byte, header.IndexLength)
-	if _, err := io.ReadFull(file, indexBytes); err != nil {
-		return fmt.Errorf("error reading index: %v", err)
+func (ca *ClipArchiver) Extract(opts ClipArchiverOptions) error {
+	file, err := os.Open(opts.ArchivePath)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
+	os.MkdirAll(opts.OutputPath, 0755)
 
-	indexReader := bytes.NewReader(indexBytes)
-	indexDec := gob.NewDecoder(indexReader)
-
-	var nodes []*common.ClipNode
-	if err := indexDec.Decode(&nodes); err != nil {
-		return fmt.Errorf("error decoding index: %v", err)
+	fi, err := file.Stat()
+	if err != nil {
+		return err
 	}
 
-	index := ca.newIndex()
-	for _, node := range nodes {
-		index.Set(node)
+	metadata, err := ca.ExtractMetadataFrom(file, fi.Size())
+	if err != nil {
+		return err
 	}
+	index := metadata.Index
 
 	// Iterate over the index and extract every node
 	index.Ascend(index.Min(), func(a interface{}) bool {
@@ -528,6 +624,11 @@ func (ca *ClipArchiver) Extract(opts ClipArchiverOptions) error {
 			os.Symlink(node.Target, path.Join(opts.OutputPath, node.Path))
 		}
 
+		// Reapply extended attributes, some (i.e. security.*) may require elevated privileges
+		if err := writeXattrs(path.Join(opts.OutputPath, node.Path), node.Xattrs, node.NodeType == common.SymLinkNode); err != nil && opts.Verbose {
+			log.Printf("error setting xattrs on %s: %v", node.Path, err)
+		}
+
 		return true
 	})
 
@@ -541,13 +642,18 @@ func (ca *ClipArchiver) writeBlocks(index *btree.BTree, sourcePath string, outFi
 	var pos int64 = offset
 
 	// Push specific directories towards the front of the archive
-	priorityDirs := []string{
-		path.Join(sourcePath, "/rootfs/usr/lib"),
-		path.Join(sourcePath, "/rootfs/usr/bin"),
-		path.Join(sourcePath, "/rootfs/usr/local/lib/python3.7/dist-packages"),
-		path.Join(sourcePath, "/rootfs/usr/local/lib/python3.8/dist-packages"),
-		path.Join(sourcePath, "/rootfs/usr/local/lib/python3.9/dist-packages"),
-		path.Join(sourcePath, "/rootfs/usr/local/lib/python3.10/dist-packages"),
+	priorityPaths := opts.PriorityPaths
+	if priorityPaths == nil {
+		priorityPaths = DefaultPriorityPaths
+	}
+
+	priorityDirs := make([]string, 0, len(priorityPaths))
+	for _, p := range priorityPaths {
+		// An empty path would match every file
+		if p == "" {
+			continue
+		}
+		priorityDirs = append(priorityDirs, path.Join(sourcePath, p))
 	}
 
 	// Create slices for priority nodes and other nodes
@@ -575,10 +681,27 @@ func (ca *ClipArchiver) writeBlocks(index *btree.BTree, sourcePath string, outFi
 		return true
 	})
 
+	// Files with identical content share a single data block
+	blocks := make(map[string]dataBlock)
+	writeNode := func(node *common.ClipNode) bool {
+		if block, exists := blocks[node.ContentHash]; exists && node.ContentHash != "" {
+			node.DataPos = block.pos
+			node.DataLen = block.len
+			return true
+		}
+
+		if !ca.processNode(node, writer, sourcePath, &pos, opts) {
+			return false
+		}
+
+		blocks[node.ContentHash] = dataBlock{pos: node.DataPos, len: node.DataLen}
+		return true
+	}
+
 	// Process priority nodes first
 	for _, node := range priorityNodes {
 		if node.NodeType == common.FileNode {
-			if !ca.processNode(node, writer, sourcePath, &pos, opts) {
+			if !writeNode(node) {
 				return fmt.Errorf("error processing priority node %s", node.Path)
 			}
 		}
@@ -587,7 +710,7 @@ func (ca *ClipArchiver) writeBlocks(index *btree.BTree, sourcePath string, outFi
 	// Process other nodes
 	for _, node := range otherNodes {
 		if node.NodeType == common.FileNode {
-			if !ca.processNode(node, writer, sourcePath, &pos, opts) {
+			if !writeNode(node) {
 				return fmt.Errorf("error processing other node %s", node.Path)
 			}
 		}
@@ -596,6 +719,12 @@ func (ca *ClipArchiver) writeBlocks(index *btree.BTree, sourcePath string, outFi
 	return nil
 }
 
+// dataBlock is the location of a file's content within the archive
+type dataBlock struct {
+	pos int64
+	len int64
+}
+
 func (ca *ClipArchiver) processNode(node *common.ClipNode, writer *bufio.Writer, sourcePath string, pos *int64, opts ClipArchiverOptions) bool {
 	if opts.Verbose {
 		log.Spinner(fmt.Sprintf("Archiving... %s", node.Path))
@@ -656,21 +785,94 @@ func (ca *ClipArchiver) processNode(node *common.ClipNode, writer *bufio.Writer,
 	return true
 }
 
+// EncodeHeader encodes a header in the layout of its format version, so headers of
+// older archives round trip to the same bytes
 func (ca *ClipArchiver) EncodeHeader(header *common.ClipArchiveHeader) ([]byte, error) {
+	var data interface{} = header
+
+	switch header.ClipFileFormatVersion {
+	case common.ClipFileFormatVersionV1:
+		data = &common.ClipArchiveHeaderV1{
+			StartBytes:            header.StartBytes,
+			ClipFileFormatVersion: header.ClipFileFormatVersion,
+			IndexLength:           header.IndexLength,
+			IndexPos:              header.IndexPos,
+			StorageInfoLength:     header.StorageInfoLength,
+			StorageInfoPos:        header.StorageInfoPos,
+			StorageInfoType:       header.StorageInfoType,
+		}
+	}
+
 	buf := new(bytes.Buffer)
-	if err := binary.Write(buf, binary.LittleEndian, header); err != nil {
+	if err := binary.Write(buf, binary.LittleEndian, data); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
 func (ca *ClipArchiver) DecodeHeader(headerBytes []byte) (*common.ClipArchiveHeader, error) {
-	header := new(common.ClipArchiveHeader)
+	if len(headerBytes) < common.ClipHeaderLengthV1 {
+		return nil, common.ErrFileHeaderMismatch
+	}
+
 	buf := bytes.NewBuffer(headerBytes)
-	if err := binary.Read(buf, binary.LittleEndian, header); err != nil {
+
+	switch headerBytes[len(common.ClipFileStartBytes)] {
+	case common.ClipFileFormatVersionV1:
+		headerV1 := new(common.ClipArchiveHeaderV1)
+		if err := binary.Read(buf, binary.LittleEndian, headerV1); err != nil {
+			return nil, err
+		}
+
+		return &common.ClipArchiveHeader{
+			StartBytes:            headerV1.StartBytes,
+			ClipFileFormatVersion: headerV1.ClipFileFormatVersion,
+			IndexLength:           headerV1.IndexLength,
+			IndexPos:              headerV1.IndexPos,
+			StorageInfoLength:     headerV1.StorageInfoLength,
+			StorageInfoPos:        headerV1.StorageInfoPos,
+			StorageInfoType:       headerV1.StorageInfoType,
+		}, nil
+	case common.ClipFileFormatVersion:
+		header := new(common.ClipArchiveHeader)
+		if err := binary.Read(buf, binary.LittleEndian, header); err != nil {
+			return nil, err
+		}
+		return header, nil
+	default:
+		return nil, common.ErrFileHeaderMismatch
+	}
+}
+
+// computeMetadataChecksum computes a crc64 over the header fields (excluding the checksum itself),
+// the encoded index and the encoded storage info
+func (ca *ClipArchiver) computeMetadataChecksum(header *common.ClipArchiveHeader, indexBytes []byte, storageInfoBytes []byte) (uint64, error) {
+	metadataHash, err := ca.newMetadataHash(header)
+	if err != nil {
+		return 0, err
+	}
+
+	metadataHash.Write(indexBytes)
+	metadataHash.Write(storageInfoBytes)
+
+	return metadataHash.Sum64(), nil
+}
+
+// newMetadataHash returns a metadata checksum hash that has already consumed the header,
+// so the index and storage info can be streamed into it
+func (ca *ClipArchiver) newMetadataHash(header *common.ClipArchiveHeader) (hash.Hash64, error) {
+	h := *header
+	h.MetadataChecksum = 0
+
+	headerBytes, err := ca.EncodeHeader(&h)
+	if err != nil {
 		return nil, err
 	}
-	return header, nil
+
+	metadataHash := crc64.New(crc64.MakeTable(crc64.ISO))
+	metadataHash.Write(headerBytes)
+
+	return metadataHash, nil
 }
 
 func (ca *ClipArchiver) EncodeIndex(index *btree.BTree) ([]byte, error) {
@@ -0,0 +1,450 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	common "github.com/beam-cloud/clip/pkg/common"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// createTestArchive writes files (by path relative to the source directory) into a
+// new archive and returns the archive path
+func createTestArchive(t *testing.T, files map[string]string, opts ClipArchiverOptions) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "src")
+	for path, content := range files {
+		path = filepath.Join(sourcePath, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts.SourcePath = sourcePath
+	opts.OutputFile = filepath.Join(dir, "test.clip")
+	if err := NewClipArchiver().Create(opts); err != nil {
+		t.Fatalf("unable to create archive: %v", err)
+	}
+
+	return opts.OutputFile
+}
+
+func readTestArchive(t *testing.T, archivePath string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func writeTestArchive(t *testing.T, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.clip")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var testFiles = map[string]string{
+	"a.txt":     "hello",
+	"dir/b.txt": "world",
+}
+
+func TestExtractMetadataCorruptIndex(t *testing.T) {
+	ca := NewClipArchiver()
+	data := readTestArchive(t, createTestArchive(t, testFiles, ClipArchiverOptions{}))
+
+	header, err := ca.DecodeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, off := range []int64{header.IndexPos, header.IndexPos + header.IndexLength/2, header.IndexPos + header.IndexLength - 1} {
+		corrupt := append([]byte(nil), data...)
+		corrupt[off] ^= 0x01
+
+		if _, err := ca.ExtractMetadata(writeTestArchive(t, corrupt)); !errors.Is(err, common.ErrMetadataCorrupt) {
+			t.Errorf("flipping index byte %d: expected ErrMetadataCorrupt, got %v", off, err)
+		}
+	}
+}
+
+func TestExtractMetadataCorruptHeader(t *testing.T) {
+	ca := NewClipArchiver()
+	data := readTestArchive(t, createTestArchive(t, testFiles, ClipArchiverOptions{}))
+
+	// Bytes of the index and storage info lengths and positions. Some flips leave the
+	// section in bounds, others move it outside the archive.
+	for _, off := range []int{10, 12, 17, 18, 20, 25, 26, 30, 33, 34, 41} {
+		corrupt := append([]byte(nil), data...)
+		corrupt[off] ^= 0x10
+
+		if _, err := ca.ExtractMetadata(writeTestArchive(t, corrupt)); !errors.Is(err, common.ErrMetadataCorrupt) {
+			t.Errorf("flipping header byte %d: expected ErrMetadataCorrupt, got %v", off, err)
+		}
+	}
+}
+
+func TestExtractMetadataOutOfBoundsWithoutChecksum(t *testing.T) {
+	ca := NewClipArchiver()
+	data := downgradeToV1(t, readTestArchive(t, createTestArchive(t, testFiles, ClipArchiverOptions{})))
+
+	header, err := ca.DecodeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.IndexLength = int64(len(data))
+
+	headerBytes, err := ca.EncodeHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data, headerBytes)
+
+	// Nothing says the header is corrupt, so this is only a read error
+	_, err = ca.ExtractMetadata(writeTestArchive(t, data))
+	if err == nil || errors.Is(err, common.ErrMetadataCorrupt) {
+		t.Fatalf("expected an out of bounds error, got %v", err)
+	}
+}
+
+func TestExtractMetadataZeroedChecksum(t *testing.T) {
+	ca := NewClipArchiver()
+	data := readTestArchive(t, createTestArchive(t, testFiles, ClipArchiverOptions{}))
+
+	header, err := ca.DecodeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Zeroing the checksum must not turn verification off, with or without other damage
+	for _, indexLength := range []int64{header.IndexLength, int64(len(data))} {
+		zeroed := *header
+		zeroed.MetadataChecksum = 0
+		zeroed.IndexLength = indexLength
+
+		headerBytes, err := ca.EncodeHeader(&zeroed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt := append([]byte(nil), data...)
+		copy(corrupt, headerBytes)
+
+		if _, err := ca.ExtractMetadata(writeTestArchive(t, corrupt)); !errors.Is(err, common.ErrMetadataCorrupt) {
+			t.Errorf("index length %d: expected ErrMetadataCorrupt, got %v", indexLength, err)
+		}
+	}
+}
+
+func TestWriteBlocksDeduplicatesContent(t *testing.T) {
+	duplicate := "the same content in every file"
+	archivePath := createTestArchive(t, map[string]string{
+		"a.txt":       duplicate,
+		"b.txt":       duplicate,
+		"dir/c.txt":   duplicate,
+		"unique.txt":  "something else",
+		"dir/empty":   "",
+		"dir/empty-2": "",
+	}, ClipArchiverOptions{})
+
+	metadata, err := NewClipArchiver().ExtractMetadata(archivePath)
+	if err != nil {
+		t.Fatalf("unable to extract metadata: %v", err)
+	}
+
+	first := metadata.Get("/a.txt")
+	for _, path := range []string{"/b.txt", "/dir/c.txt"} {
+		node := metadata.Get(path)
+		if node.DataPos != first.DataPos || node.DataLen != first.DataLen {
+			t.Errorf("%s at %d (%d bytes), expected to share %d (%d bytes) with /a.txt", path, node.DataPos, node.DataLen, first.DataPos, first.DataLen)
+		}
+	}
+	if unique := metadata.Get("/unique.txt"); unique.DataPos == first.DataPos {
+		t.Errorf("/unique.txt shares data with /a.txt")
+	}
+
+	// Only one block is stored for the duplicated content and one for the empty files. Each
+	// block is a block type byte, the content and a checksum.
+	dataLen := metadata.Header.IndexPos - common.ClipHeaderLength
+	if want := int64(len(duplicate)+len("something else")) + 3*(1+ChecksumLength); dataLen != want {
+		t.Fatalf("expected %d bytes of file data, got %d", want, dataLen)
+	}
+
+	// Every file still reads back its own content
+	extractPath := t.TempDir()
+	if err := NewClipArchiver().Extract(ClipArchiverOptions{ArchivePath: archivePath, OutputPath: extractPath}); err != nil {
+		t.Fatalf("unable to extract archive: %v", err)
+	}
+	for _, path := range []string{"a.txt", "b.txt", "dir/c.txt"} {
+		data, err := os.ReadFile(filepath.Join(extractPath, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != duplicate {
+			t.Errorf("%s: expected %q, got %q", path, duplicate, data)
+		}
+	}
+}
+
+func TestWriteBlocksPriorityPaths(t *testing.T) {
+	files := map[string]string{
+		"a/first.txt":                   "sorts first",
+		"rootfs/usr/lib/libexample.so":  "default priority",
+		"z/custom.txt":                  "custom priority",
+		"rootfs/usr/share/doc/readme":   "not a priority",
+		"rootfs/usr/bin/example-binary": "default priority too",
+	}
+
+	tests := []struct {
+		name          string
+		priorityPaths []string
+		first         []string // Files that must come before every other file
+	}{
+		{name: "default", priorityPaths: nil, first: []string{"/rootfs/usr/lib/libexample.so", "/rootfs/usr/bin/example-binary"}},
+		{name: "custom", priorityPaths: []string{"/z"}, first: []string{"/z/custom.txt"}},
+		{name: "disabled", priorityPaths: []string{}, first: []string{"/a/first.txt"}},
+		{name: "empty path", priorityPaths: []string{""}, first: []string{"/a/first.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := createTestArchive(t, files, ClipArchiverOptions{PriorityPaths: tt.priorityPaths})
+			metadata, err := NewClipArchiver().ExtractMetadata(archivePath)
+			if err != nil {
+				t.Fatalf("unable to extract metadata: %v", err)
+			}
+
+			isFirst := make(map[string]bool)
+			var lastFirst int64
+			for _, path := range tt.first {
+				isFirst[path] = true
+				if pos := metadata.Get(path).DataPos; pos > lastFirst {
+					lastFirst = pos
+				}
+			}
+
+			for path := range files {
+				path = "/" + path
+				if node := metadata.Get(path); !isFirst[path] && node.DataPos < lastFirst {
+					t.Errorf("%s at %d was written before the priority files", path, node.DataPos)
+				}
+			}
+		})
+	}
+}
+
+// downgradeToV1 rewrites the header of an archive in the version 1 layout, padded to the
+// current header length so data positions stay valid. Version 1 has no checksum or node table.
+func downgradeToV1(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	ca := NewClipArchiver()
+	header, err := ca.DecodeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.ClipFileFormatVersion = common.ClipFileFormatVersionV1
+
+	headerBytes, err := ca.EncodeHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headerBytes) != common.ClipHeaderLengthV1 {
+		t.Fatalf("expected a %d byte version 1 header, got %d bytes", common.ClipHeaderLengthV1, len(headerBytes))
+	}
+
+	v1 := append([]byte(nil), data...)
+	copy(v1[:common.ClipHeaderLength], make([]byte, common.ClipHeaderLength))
+	copy(v1, headerBytes)
+
+	return v1
+}
+
+func TestExtractMetadataFrom(t *testing.T) {
+	ca := NewClipArchiver()
+	data := readTestArchive(t, createTestArchive(t, testFiles, ClipArchiverOptions{}))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		version  uint8
+		checksum bool
+	}{
+		{name: "current", data: data, version: common.ClipFileFormatVersion, checksum: true},
+		{name: "version 1", data: downgradeToV1(t, data), version: common.ClipFileFormatVersionV1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, err := ca.ExtractMetadataFrom(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatalf("unable to extract metadata: %v", err)
+			}
+
+			if metadata.Header.ClipFileFormatVersion != tt.version {
+				t.Errorf("expected version %d, got %d", tt.version, metadata.Header.ClipFileFormatVersion)
+			}
+			if hasChecksum := metadata.Header.MetadataChecksum != 0; hasChecksum != tt.checksum {
+				t.Errorf("expected checksum present to be %v, got %v", tt.checksum, hasChecksum)
+			}
+			if metadata.StorageInfo != nil {
+				t.Errorf("expected no storage info, got %v", metadata.StorageInfo)
+			}
+
+			if metadata.Get("/") == nil || metadata.Get("/dir") == nil {
+				t.Errorf("expected root and directory nodes")
+			}
+			for path, content := range testFiles {
+				node := metadata.Get("/" + path)
+				if node == nil {
+					t.Errorf("missing node for %s", path)
+					continue
+				}
+				if got := string(tt.data[node.DataPos : node.DataPos+node.DataLen]); got != content {
+					t.Errorf("%s: expected %q at DataPos, got %q", path, content, got)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractMetadataFromTruncated(t *testing.T) {
+	ca := NewClipArchiver()
+	data := readTestArchive(t, createTestArchive(t, testFiles, ClipArchiverOptions{}))
+
+	if _, err := ca.ExtractMetadataFrom(bytes.NewReader(data[:20]), 20); !errors.Is(err, common.ErrFileHeaderMismatch) {
+		t.Errorf("short header: expected ErrFileHeaderMismatch, got %v", err)
+	}
+
+	// The index ends past the reported size
+	header, err := ca.DecodeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := header.IndexPos + header.IndexLength - 1
+	if _, err := ca.ExtractMetadataFrom(bytes.NewReader(data[:size]), size); !errors.Is(err, common.ErrMetadataCorrupt) {
+		t.Errorf("truncated index: expected ErrMetadataCorrupt, got %v", err)
+	}
+
+	// Without a checksum a truncated index is only a read error
+	v1 := downgradeToV1(t, data)
+	if _, err := ca.ExtractMetadataFrom(bytes.NewReader(v1[:size]), size); err == nil || errors.Is(err, common.ErrMetadataCorrupt) {
+		t.Errorf("truncated version 1 index: expected a read error, got %v", err)
+	}
+}
+
+// generateTestArchive builds an in-memory archive with an index of n file nodes and no data
+func generateTestArchive(tb testing.TB, n int) []byte {
+	tb.Helper()
+
+	ca := NewClipArchiver()
+	index := ca.newIndex()
+	index.Set(&common.ClipNode{Path: "/", NodeType: common.DirNode, Attr: fuse.Attr{Mode: fuse.S_IFDIR | 0755}})
+	for i := 0; i < n; i++ {
+		hash := sha256.Sum256([]byte(strconv.Itoa(i)))
+		index.Set(&common.ClipNode{
+			Path:        fmt.Sprintf("/dir-%d/file-%d", i/100, i),
+			NodeType:    common.FileNode,
+			DataPos:     int64(common.ClipHeaderLength),
+			ContentHash: hex.EncodeToString(hash[:]),
+			Attr:        fuse.Attr{Ino: uint64(i + 2), Mode: fuse.S_IFREG | 0644, Mtime: 1600000000, Nlink: 1},
+		})
+	}
+
+	indexBytes, err := ca.EncodeIndex(index)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	header := common.ClipArchiveHeader{
+		ClipFileFormatVersion: common.ClipFileFormatVersion,
+		IndexLength:           int64(len(indexBytes)),
+		IndexPos:              int64(common.ClipHeaderLength),
+	}
+	copy(header.StartBytes[:], common.ClipFileStartBytes)
+	header.MetadataChecksum, err = ca.computeMetadataChecksum(&header, indexBytes, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	headerBytes, err := ca.EncodeHeader(&header)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return append(headerBytes, indexBytes...)
+}
+
+func BenchmarkExtractMetadata(b *testing.B) {
+	const files = 100000
+
+	ca := NewClipArchiver()
+	data := generateTestArchive(b, files)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		metadata, err := ca.ExtractMetadataFrom(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if got := metadata.Index.Len(); got != files+1 {
+			b.Fatalf("expected %d nodes, got %d", files+1, got)
+		}
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	large := make([]byte, 1<<20+7) // Larger than the 32Kb io.Copy buffer, and not a multiple of it
+	for i := range large {
+		large[i] = byte(i * 31)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "empty", content: nil},
+		{name: "small", content: []byte("hello")},
+		{name: "larger than the copy buffer", content: large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := hashFile(path)
+			if err != nil {
+				t.Fatalf("unable to hash file: %v", err)
+			}
+			sum := sha256.Sum256(tt.content)
+			if want := hex.EncodeToString(sum[:]); got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		})
+	}
+
+	if _, err := hashFile(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"sort"
+
+	common "github.com/beam-cloud/clip/pkg/common"
+	"github.com/tidwall/btree"
+)
+
+var nodeTableCrcTable = crc64.MakeTable(crc64.ISO)
+
+// nodeTableHeaderChecksum computes the checksum over the table header fields and the prelude
+func nodeTableHeaderChecksum(tableHeader common.ClipNodeTableHeader, prelude []byte) uint64 {
+	var fields [16]byte
+	binary.LittleEndian.PutUint64(fields[0:], uint64(tableHeader.Count))
+	binary.LittleEndian.PutUint64(fields[8:], uint64(tableHeader.PreludeLength))
+
+	checksum := crc64.Update(0, nodeTableCrcTable, fields[:])
+	return crc64.Update(checksum, nodeTableCrcTable, prelude)
+}
+
+// encodeNodeTable encodes the node table for an index, to be written at tablePos
+func (ca *ClipArchiver) encodeNodeTable(index *btree.BTree, tablePos int64) ([]byte, error) {
+	var nodes []*common.ClipNode
+	index.Ascend(index.Min(), func(a interface{}) bool {
+		nodes = append(nodes, a.(*common.ClipNode))
+		return true
+	})
+
+	// The first value sent on a gob stream carries the type definitions, so encode an
+	// empty node first and every following node is just its value
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(&common.ClipNode{}); err != nil {
+		return nil, err
+	}
+	prelude := append([]byte(nil), buf.Bytes()...)
+
+	nodePos := tablePos + common.ClipNodeTableHeaderLength + int64(len(nodes))*common.ClipNodeTableEntryLength + int64(len(prelude))
+	entries := make([]common.ClipNodeTableEntry, len(nodes))
+
+	var nodeBytes bytes.Buffer
+	for i, node := range nodes {
+		buf.Reset()
+		if err := enc.Encode(node); err != nil {
+			return nil, err
+		}
+
+		entries[i] = common.ClipNodeTableEntry{
+			NodePos:  nodePos,
+			NodeLen:  int64(buf.Len()),
+			Checksum: crc64.Checksum(buf.Bytes(), nodeTableCrcTable),
+		}
+		nodePos += int64(buf.Len())
+		nodeBytes.Write(buf.Bytes())
+	}
+
+	var table bytes.Buffer
+	tableHeader := common.ClipNodeTableHeader{Count: int64(len(nodes)), PreludeLength: int64(len(prelude))}
+	tableHeader.Checksum = nodeTableHeaderChecksum(tableHeader, prelude)
+	if err := binary.Write(&table, binary.LittleEndian, tableHeader); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&table, binary.LittleEndian, entries); err != nil {
+		return nil, err
+	}
+	table.Write(prelude)
+	table.Write(nodeBytes.Bytes())
+
+	return table.Bytes(), nil
+}
+
+// writeNodeTable appends the node table for index to the current position of outFile
+// and records its location in the header
+func (ca *ClipArchiver) writeNodeTable(outFile *os.File, index *btree.BTree, header *common.ClipArchiveHeader) error {
+	tablePos, err := outFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	tableBytes, err := ca.encodeNodeTable(index, tablePos)
+	if err != nil {
+		return err
+	}
+
+	if _, err := outFile.Write(tableBytes); err != nil {
+		return err
+	}
+
+	header.NodeTableLength = int64(len(tableBytes))
+	header.NodeTablePos = tablePos
+
+	return nil
+}
+
+// GetNode looks up a single node without loading the whole index. Archives without a
+// node table fall back to decoding the full index. Returns nil if no node exists at path.
+// The parts of the table a lookup reads are checksummed, so a corrupt table returns
+// ErrMetadataCorrupt rather than a wrong node.
+func (ca *ClipArchiver) GetNode(archivePath string, path string) (*common.ClipNode, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := ca.readHeader(file, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	if header.NodeTableLength == 0 {
+		metadata, err := ca.ExtractMetadataFrom(file, fi.Size())
+		if err != nil {
+			return nil, err
+		}
+		return metadata.Get(path), nil
+	}
+
+	table, err := openNodeTable(file, header, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	return table.lookup(path)
+}
+
+type nodeTable struct {
+	r          io.ReaderAt
+	entriesPos int64
+	count      int64
+	prelude    []byte
+	end        int64
+}
+
+func openNodeTable(r io.ReaderAt, header *common.ClipArchiveHeader, size int64) (*nodeTable, error) {
+	end := header.NodeTablePos + header.NodeTableLength
+	if header.NodeTablePos < 0 || header.NodeTableLength < common.ClipNodeTableHeaderLength || end > size {
+		return nil, fmt.Errorf("%w: node table out of bounds", common.ErrMetadataCorrupt)
+	}
+
+	tableHeaderBytes := make([]byte, common.ClipNodeTableHeaderLength)
+	if err := readFullAt(r, tableHeaderBytes, header.NodeTablePos); err != nil {
+		return nil, fmt.Errorf("error reading node table: %v", err)
+	}
+
+	var tableHeader common.ClipNodeTableHeader
+	if err := binary.Read(bytes.NewReader(tableHeaderBytes), binary.LittleEndian, &tableHeader); err != nil {
+		return nil, fmt.Errorf("error reading node table: %v", err)
+	}
+
+	entriesPos := header.NodeTablePos + common.ClipNodeTableHeaderLength
+	preludePos := entriesPos + tableHeader.Count*common.ClipNodeTableEntryLength
+	if tableHeader.Count < 0 || tableHeader.PreludeLength < 0 || preludePos < entriesPos || preludePos+tableHeader.PreludeLength > end {
+		return nil, fmt.Errorf("%w: node table header out of bounds", common.ErrMetadataCorrupt)
+	}
+
+	prelude := make([]byte, tableHeader.PreludeLength)
+	if err := readFullAt(r, prelude, preludePos); err != nil {
+		return nil, fmt.Errorf("error reading node table: %v", err)
+	}
+
+	if nodeTableHeaderChecksum(tableHeader, prelude) != tableHeader.Checksum {
+		return nil, fmt.Errorf("%w: node table header checksum mismatch", common.ErrMetadataCorrupt)
+	}
+
+	return &nodeTable{
+		r:          r,
+		entriesPos: entriesPos,
+		count:      tableHeader.Count,
+		prelude:    prelude,
+		end:        end,
+	}, nil
+}
+
+// lookup binary searches the table for path, decoding only the nodes it visits
+func (t *nodeTable) lookup(path string) (*common.ClipNode, error) {
+	var searchErr error
+	i := sort.Search(int(t.count), func(i int) bool {
+		node, err := t.node(int64(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return node.Path >= path
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+
+	if int64(i) == t.count {
+		return nil, nil
+	}
+
+	node, err := t.node(int64(i))
+	if err != nil {
+		return nil, err
+	}
+	if node.Path != path {
+		return nil, nil
+	}
+
+	return node, nil
+}
+
+func (t *nodeTable) node(i int64) (*common.ClipNode, error) {
+	entryBytes := make([]byte, common.ClipNodeTableEntryLength)
+	if err := readFullAt(t.r, entryBytes, t.entriesPos+i*common.ClipNodeTableEntryLength); err != nil {
+		return nil, fmt.Errorf("error reading node table: %v", err)
+	}
+
+	var entry common.ClipNodeTableEntry
+	if err := binary.Read(bytes.NewReader(entryBytes), binary.LittleEndian, &entry); err != nil {
+		return nil, fmt.Errorf("error reading node table: %v", err)
+	}
+
+	if entry.NodePos < t.entriesPos || entry.NodeLen < 0 || entry.NodePos+entry.NodeLen > t.end {
+		return nil, fmt.Errorf("%w: node table entry %d out of bounds", common.ErrMetadataCorrupt, i)
+	}
+
+	nodeBytes := make([]byte, entry.NodeLen)
+	if err := readFullAt(t.r, nodeBytes, entry.NodePos); err != nil {
+		return nil, fmt.Errorf("error reading node table: %v", err)
+	}
+
+	if crc64.Checksum(nodeBytes, nodeTableCrcTable) != entry.Checksum {
+		return nil, fmt.Errorf("%w: node table entry %d checksum mismatch", common.ErrMetadataCorrupt, i)
+	}
+
+	dec := gob.NewDecoder(io.MultiReader(bytes.NewReader(t.prelude), bytes.NewReader(nodeBytes)))
+
+	var prototype common.ClipNode
+	if err := dec.Decode(&prototype); err != nil {
+		return nil, fmt.Errorf("error decoding node table: %v", err)
+	}
+
+	node := new(common.ClipNode)
+	if err := dec.Decode(node); err != nil {
+		return nil, fmt.Errorf("error decoding node table: %v", err)
+	}
+
+	return node, nil
+}
@@ -0,0 +1,139 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	common "github.com/beam-cloud/clip/pkg/common"
+)
+
+var nodeTableFiles = map[string]string{
+	"a.txt":           "a",
+	"dir/b.txt":       "b",
+	"dir/nested/c.sh": "c",
+	"zz-last.txt":     "last",
+}
+
+func TestGetNode(t *testing.T) {
+	ca := NewClipArchiver()
+
+	withTable := createTestArchive(t, nodeTableFiles, ClipArchiverOptions{NodeTable: true})
+	withoutTable := createTestArchive(t, nodeTableFiles, ClipArchiverOptions{})
+
+	for name, archivePath := range map[string]string{"node table": withTable, "full index": withoutTable} {
+		t.Run(name, func(t *testing.T) {
+			metadata, err := ca.ExtractMetadata(archivePath)
+			if err != nil {
+				t.Fatalf("unable to extract metadata: %v", err)
+			}
+			if hasTable := metadata.Header.NodeTableLength > 0; hasTable != (archivePath == withTable) {
+				t.Fatalf("unexpected node table length %d", metadata.Header.NodeTableLength)
+			}
+
+			// Every node, including the first ("/") and the last path
+			metadata.Index.Ascend(metadata.Index.Min(), func(a interface{}) bool {
+				want := a.(*common.ClipNode)
+				got, err := ca.GetNode(archivePath, want.Path)
+				if err != nil {
+					t.Errorf("GetNode(%q) failed: %v", want.Path, err)
+				} else if !reflect.DeepEqual(got, want) {
+					t.Errorf("GetNode(%q) = %+v, want %+v", want.Path, got, want)
+				}
+				return true
+			})
+
+			// Misses before the first path, between paths and after the last one
+			for _, path := range []string{"", "/a", "/dir/b", "/missing", "/zzz"} {
+				node, err := ca.GetNode(archivePath, path)
+				if err != nil || node != nil {
+					t.Errorf("GetNode(%q) = (%v, %v), want no node", path, node, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetNodeRemoteArchiveKeepsTable(t *testing.T) {
+	ca := NewClipArchiver()
+
+	metadata, err := ca.ExtractMetadata(createTestArchive(t, nodeTableFiles, ClipArchiverOptions{NodeTable: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remotePath := filepath.Join(t.TempDir(), "test.rclip")
+	if err := ca.CreateRemoteArchive(common.HTTPStorageInfo{URL: "http://localhost/test.clip"}, metadata, remotePath); err != nil {
+		t.Fatalf("unable to create remote archive: %v", err)
+	}
+
+	node, err := ca.GetNode(remotePath, "/dir/nested/c.sh")
+	if err != nil || node == nil {
+		t.Fatalf("GetNode failed: (%v, %v)", node, err)
+	}
+	if want := metadata.Get("/dir/nested/c.sh"); node.DataPos != want.DataPos || node.DataLen != want.DataLen {
+		t.Fatalf("expected data at %d (%d bytes), got %d (%d bytes)", want.DataPos, want.DataLen, node.DataPos, node.DataLen)
+	}
+}
+
+func TestGetNodeCorruptTable(t *testing.T) {
+	ca := NewClipArchiver()
+	data := readTestArchive(t, createTestArchive(t, nodeTableFiles, ClipArchiverOptions{NodeTable: true}))
+
+	metadata, err := ca.ExtractMetadataFrom(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	metadata.Index.Ascend(metadata.Index.Min(), func(a interface{}) bool {
+		paths = append(paths, a.(*common.ClipNode).Path)
+		return true
+	})
+
+	tablePos := metadata.Header.NodeTablePos
+	entriesPos := tablePos + common.ClipNodeTableHeaderLength
+	preludePos := entriesPos + int64(len(paths))*common.ClipNodeTableEntryLength
+	tableEnd := tablePos + metadata.Header.NodeTableLength
+
+	tests := []struct {
+		name string
+		off  int64
+	}{
+		{name: "table count", off: tablePos},
+		{name: "table checksum", off: tablePos + 16},
+		{name: "entry position", off: entriesPos},
+		{name: "entry length", off: entriesPos + 8},
+		{name: "entry checksum", off: entriesPos + 16},
+		{name: "last entry", off: preludePos - 1},
+		{name: "prelude", off: preludePos + 2},
+		{name: "last node", off: tableEnd - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupt := append([]byte(nil), data...)
+			corrupt[tt.off] ^= 0x01
+			archivePath := writeTestArchive(t, corrupt)
+
+			// Some lookup must read the corrupt byte, and none may quietly succeed with a wrong node
+			var corruptErr bool
+			for _, path := range paths {
+				node, err := ca.GetNode(archivePath, path)
+				switch {
+				case errors.Is(err, common.ErrMetadataCorrupt):
+					corruptErr = true
+				case err != nil:
+					t.Errorf("GetNode(%q): expected ErrMetadataCorrupt, got %v", path, err)
+				case node == nil || node.Path != path:
+					t.Errorf("GetNode(%q) returned %+v", path, node)
+				}
+			}
+			if !corruptErr {
+				t.Errorf("no lookup detected the corruption")
+			}
+		})
+	}
+}
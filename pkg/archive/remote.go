@@ -13,6 +13,7 @@ import (
 
 func init() {
 	gob.Register(&common.S3StorageInfo{})
+	gob.Register(&common.HTTPStorageInfo{})
 }
 
 type RClipArchiver struct {
@@ -36,14 +37,22 @@ func (rca *RClipArchiver) Create(ctx context.Context, archivePath string, output
 	switch rca.StorageInfo.Type() {
 	case "s3":
 		var storageInfo *common.S3StorageInfo = rca.StorageInfo.(*common.S3StorageInfo)
-		clipStorage, err := storage.NewS3ClipStorage(metadata, storage.S3ClipStorageOpts{
-			Region:    storageInfo.Region,
-			Bucket:    storageInfo.Bucket,
-			Key:       storageInfo.Key,
-			Endpoint:  storageInfo.Endpoint,
-			AccessKey: credentials.S3.AccessKey,
-			SecretKey: credentials.S3.SecretKey,
-		})
+		s3Opts := storage.S3ClipStorageOpts{
+			Region:                storageInfo.Region,
+			Bucket:                storageInfo.Bucket,
+			Key:                   storageInfo.Key,
+			Endpoint:              storageInfo.Endpoint,
+			SSEMode:               storageInfo.SSEMode,
+			KMSKeyID:              storageInfo.KMSKeyID,
+			StorageClass:          storageInfo.StorageClass,
+			UseDefaultCredentials: storageInfo.UseDefaultCredentials,
+		}
+		if credentials.S3 != nil {
+			s3Opts.AccessKey = credentials.S3.AccessKey
+			s3Opts.SecretKey = credentials.S3.SecretKey
+		}
+
+		clipStorage, err := storage.NewS3ClipStorage(metadata, s3Opts)
 		if err != nil {
 			return err
 		}
@@ -61,6 +70,13 @@ func (rca *RClipArchiver) Create(ctx context.Context, archivePath string, output
 			os.Remove(outputPath)
 			return err
 		}
+	case "http":
+		// The original archive is hosted by the caller, so there is nothing to upload
+		log.Println("Creating an RCLIP backed by http")
+		err = rca.ClipArchiver.CreateRemoteArchive(rca.StorageInfo, metadata, outputPath)
+		if err != nil {
+			return err
+		}
 	default:
 		return errors.New("unsupported storage type")
 	}
@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	common "github.com/beam-cloud/clip/pkg/common"
+)
+
+// ExtractToTar writes the contents of a local archive to w as a tar stream, in path order
+func (ca *ClipArchiver) ExtractToTar(archivePath string, w io.Writer) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	metadata, err := ca.ExtractMetadataFrom(file, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	if metadata.StorageInfo != nil {
+		return fmt.Errorf("unable to convert a remote archive to tar, storage type: %s", metadata.StorageInfo.Type())
+	}
+
+	tw := tar.NewWriter(w)
+
+	var walkErr error
+	metadata.Index.Ascend(metadata.Index.Min(), func(a interface{}) bool {
+		node := a.(*common.ClipNode)
+
+		// The root directory has no entry of its own in a tar stream
+		name := strings.TrimPrefix(node.Path, "/")
+		if name == "" {
+			return true
+		}
+
+		hdr := tarHeaderFromClipNode(node, name)
+		if err := tw.WriteHeader(hdr); err != nil {
+			walkErr = fmt.Errorf("error writing tar header for %s: %v", node.Path, err)
+			return false
+		}
+
+		if node.NodeType == common.FileNode && node.DataLen > 0 {
+			if _, err := io.Copy(tw, io.NewSectionReader(file, node.DataPos, node.DataLen)); err != nil {
+				walkErr = fmt.Errorf("error writing tar data for %s: %v", node.Path, err)
+				return false
+			}
+		}
+
+		return true
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
+	return tw.Close()
+}
+
+func tarHeaderFromClipNode(node *common.ClipNode, name string) *tar.Header {
+	hdr := &tar.Header{
+		Name:       name,
+		Mode:       int64(node.Attr.Mode & 07777),
+		Uid:        int(node.Attr.Owner.Uid),
+		Gid:        int(node.Attr.Owner.Gid),
+		ModTime:    time.Unix(int64(node.Attr.Mtime), int64(node.Attr.Mtimensec)),
+		AccessTime: time.Unix(int64(node.Attr.Atime), int64(node.Attr.Atimensec)),
+		ChangeTime: time.Unix(int64(node.Attr.Ctime), int64(node.Attr.Ctimensec)),
+	}
+
+	switch node.NodeType {
+	case common.DirNode:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case common.SymLinkNode:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = node.Target
+	default:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = node.DataLen
+	}
+
+	// Extended attributes are carried as PAX records, the same way GNU tar stores them
+	if len(node.Xattrs) > 0 {
+		hdr.PAXRecords = make(map[string]string, len(node.Xattrs))
+		for name, value := range node.Xattrs {
+			hdr.PAXRecords["SCHILY.xattr."+name] = string(value)
+		}
+	}
+
+	return hdr
+}
@@ -3,6 +3,7 @@ package archive
 import (
 	"encoding/binary"
 	"hash/crc64"
+	"io"
 )
 
 const ChecksumLength = 8
@@ -17,3 +18,15 @@ func computeChecksum(data []byte) []byte {
 
 	return checksumBytes
 }
+
+// readFullAt fills buf from r at off. An io.EOF is only an error if buf could not be filled.
+func readFullAt(r io.ReaderAt, buf []byte, off int64) error {
+	n, err := r.ReadAt(buf, off)
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package archive
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path without following symlinks.
+// Filesystems that don't support xattrs yield no attributes rather than an error.
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string][]byte)
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+
+		valueSize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			if errors.Is(err, unix.ENODATA) {
+				continue
+			}
+			return nil, err
+		}
+
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Lgetxattr(path, name, value)
+		if err != nil {
+			return nil, err
+		}
+
+		xattrs[name] = value[:valueSize]
+	}
+
+	return xattrs, nil
+}
+
+// writeXattrs applies extended attributes to path without following symlinks. Linux only
+// allows user.* attributes on regular files and directories, so they're skipped on symlinks.
+func writeXattrs(path string, xattrs map[string][]byte, symlink bool) error {
+	for name, value := range xattrs {
+		if symlink && strings.HasPrefix(name, "user.") {
+			continue
+		}
+		if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTestXattr sets an xattr on path, skipping the test if the filesystem doesn't support them
+func setTestXattr(t *testing.T, path string, name string, value []byte) {
+	t.Helper()
+
+	if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			t.Skipf("filesystem doesn't support user xattrs: %v", err)
+		}
+		t.Fatal(err)
+	}
+}
+
+func TestXattrsRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(sourcePath, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sourcePath, "dir", "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(sourcePath, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]map[string][]byte{
+		"dir":      {"user.dir": []byte("directory")},
+		"dir/file": {"user.test": []byte("value"), "user.empty": {}, "user.binary": {0x00, 0xff, 0x10}},
+	}
+	for path, xattrs := range want {
+		for name, value := range xattrs {
+			setTestXattr(t, filepath.Join(sourcePath, path), name, value)
+		}
+	}
+
+	archivePath := filepath.Join(dir, "test.clip")
+	ca := NewClipArchiver()
+	if err := ca.Create(ClipArchiverOptions{SourcePath: sourcePath, OutputFile: archivePath}); err != nil {
+		t.Fatalf("unable to create archive: %v", err)
+	}
+
+	outputPath := filepath.Join(dir, "out")
+	if err := ca.Extract(ClipArchiverOptions{ArchivePath: archivePath, OutputPath: outputPath}); err != nil {
+		t.Fatalf("unable to extract archive: %v", err)
+	}
+
+	for path, xattrs := range want {
+		for name, value := range xattrs {
+			buf := make([]byte, 64)
+			n, err := unix.Lgetxattr(filepath.Join(outputPath, path), name, buf)
+			if err != nil {
+				t.Errorf("%s: unable to get %s: %v", path, name, err)
+				continue
+			}
+			if !bytes.Equal(buf[:n], value) {
+				t.Errorf("%s: expected %s to be %q, got %q", path, name, value, buf[:n])
+			}
+		}
+
+		got, err := readXattrs(filepath.Join(outputPath, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(xattrs) {
+			t.Errorf("%s: expected %d xattrs, got %v", path, len(xattrs), got)
+		}
+	}
+
+	if target, err := os.Readlink(filepath.Join(outputPath, "link")); err != nil || target != "dir/file" {
+		t.Errorf("expected the symlink to point to dir/file, got %q (%v)", target, err)
+	}
+}
+
+func TestWriteXattrsSkipsUserOnSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("target", link); err != nil {
+		t.Fatal(err)
+	}
+
+	// Linux rejects user.* on symlinks, which would otherwise fail the whole node
+	if err := writeXattrs(link, map[string][]byte{"user.test": []byte("value")}, true); err != nil {
+		t.Fatalf("expected user xattrs to be skipped on a symlink, got %v", err)
+	}
+
+	xattrs, err := readXattrs(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xattrs) != 0 {
+		t.Errorf("expected no xattrs on the symlink, got %v", xattrs)
+	}
+}
@@ -0,0 +1,157 @@
+package clip
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/archive"
+	"github.com/beam-cloud/clip/pkg/common"
+	"github.com/beam-cloud/clip/pkg/storage"
+)
+
+const (
+	BenchPatternSequential = "sequential"
+	BenchPatternRandom     = "random"
+
+	defaultBenchReadSize = 1 << 17 // 128Kb, matches the FUSE MaxReadAhead
+)
+
+type BenchOptions struct {
+	ArchivePath string
+	CachePath   string
+	Files       int
+	Pattern     string
+	ReadSize    int
+	Credentials storage.ClipStorageCredentials
+}
+
+type BenchResult struct {
+	Files         int
+	Reads         int
+	Bytes         int64
+	Duration      time.Duration
+	P50           time.Duration
+	P99           time.Duration
+	CacheHits     int // Reads served while the archive was cached locally
+	CacheMisses   int // Reads that went to the archive's remote source
+	CachedLocally bool
+}
+
+// Throughput returns the read throughput in bytes per second
+func (r *BenchResult) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// BenchArchive reads a sample of files from an archive through the storage layer
+// and reports throughput and per-read latency
+func BenchArchive(options BenchOptions) (*BenchResult, error) {
+	if options.Pattern == "" {
+		options.Pattern = BenchPatternSequential
+	}
+	if options.Pattern != BenchPatternSequential && options.Pattern != BenchPatternRandom {
+		return nil, fmt.Errorf("unsupported bench pattern: %s", options.Pattern)
+	}
+	if options.ReadSize <= 0 {
+		options.ReadSize = defaultBenchReadSize
+	}
+
+	ca := archive.NewClipArchiver()
+	metadata, err := ca.ExtractMetadata(options.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %v", err)
+	}
+
+	s, err := storage.NewClipStorageWithOpts(storage.ClipStorageOpts{
+		ArchivePath: options.ArchivePath,
+		CachePath:   options.CachePath,
+		Metadata:    metadata,
+		Credentials: options.Credentials,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load storage: %v", err)
+	}
+	defer s.Cleanup()
+
+	nodes := benchSample(metadata, options.Files, options.Pattern)
+	log.Printf("Benchmarking %d files from %s (%s)\n", len(nodes), options.ArchivePath, options.Pattern)
+
+	var latencies []time.Duration
+	var total int64
+	var hits, misses int
+	buf := make([]byte, options.ReadSize)
+
+	startTime := time.Now()
+	for _, node := range nodes {
+		for off := int64(0); off < node.DataLen; off += int64(len(buf)) {
+			dest := buf
+			if remaining := node.DataLen - off; remaining < int64(len(dest)) {
+				dest = dest[:remaining]
+			}
+
+			// Storage reads from its local cache whenever it's cached locally at the time of the read
+			if s.CachedLocally() {
+				hits++
+			} else {
+				misses++
+			}
+
+			readStart := time.Now()
+			n, err := s.ReadFile(node, dest, off)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %v", node.Path, err)
+			}
+
+			latencies = append(latencies, time.Since(readStart))
+			total += int64(n)
+		}
+	}
+
+	result := &BenchResult{
+		Files:         len(nodes),
+		Reads:         len(latencies),
+		Bytes:         total,
+		Duration:      time.Since(startTime),
+		CacheHits:     hits,
+		CacheMisses:   misses,
+		CachedLocally: s.CachedLocally(),
+	}
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		result.P50 = latencies[len(latencies)*50/100]
+		result.P99 = latencies[len(latencies)*99/100]
+	}
+
+	return result, nil
+}
+
+// benchSample selects up to n non-empty files from the archive. Sequential samples are
+// ordered by their position in the archive, random samples are shuffled.
+func benchSample(metadata *common.ClipArchiveMetadata, n int, pattern string) []*common.ClipNode {
+	var nodes []*common.ClipNode
+	metadata.Index.Ascend(metadata.Index.Min(), func(a interface{}) bool {
+		node := a.(*common.ClipNode)
+		if node.NodeType == common.FileNode && node.DataLen > 0 {
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+
+	if pattern == BenchPatternRandom {
+		rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	} else {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].DataPos < nodes[j].DataPos })
+	}
+
+	if n > 0 && n < len(nodes) {
+		nodes = nodes[:n]
+	}
+
+	return nodes
+}
@@ -0,0 +1,61 @@
+package clip
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBenchArchive(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "src")
+
+	files := map[string]string{
+		"a.txt":     "hello",
+		"dir/b.txt": "world",
+		"empty":     "",
+	}
+	for path, content := range files {
+		path = filepath.Join(sourcePath, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePath := filepath.Join(dir, "test.clip")
+	if err := CreateArchive(CreateOptions{InputPath: sourcePath, OutputPath: archivePath}); err != nil {
+		t.Fatalf("unable to create archive: %v", err)
+	}
+
+	for _, pattern := range []string{BenchPatternSequential, BenchPatternRandom} {
+		t.Run(pattern, func(t *testing.T) {
+			result, err := BenchArchive(BenchOptions{ArchivePath: archivePath, Pattern: pattern, ReadSize: 2})
+			if err != nil {
+				t.Fatalf("unable to bench archive: %v", err)
+			}
+
+			// Empty files aren't sampled, and each 5 byte file takes 3 reads of 2 bytes
+			if result.Files != 2 {
+				t.Errorf("expected 2 files, got %d", result.Files)
+			}
+			if result.Reads != 6 {
+				t.Errorf("expected 6 reads, got %d", result.Reads)
+			}
+			if want := int64(len("hello") + len("world")); result.Bytes != want {
+				t.Errorf("expected %d bytes, got %d", want, result.Bytes)
+			}
+
+			// Local archives are always cached locally
+			if result.CacheHits != result.Reads || result.CacheMisses != 0 {
+				t.Errorf("expected %d cache hits and no misses, got %d hits and %d misses", result.Reads, result.CacheHits, result.CacheMisses)
+			}
+		})
+	}
+
+	if _, err := BenchArchive(BenchOptions{ArchivePath: archivePath, Pattern: "backwards"}); err == nil {
+		t.Errorf("expected an error for an unsupported pattern")
+	}
+}
@@ -3,6 +3,7 @@ package clip
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -17,11 +18,13 @@ import (
 )
 
 type CreateOptions struct {
-	InputPath    string
-	OutputPath   string
-	Verbose      bool
-	Credentials  storage.ClipStorageCredentials
-	ProgressChan chan<- int
+	InputPath     string
+	OutputPath    string
+	Verbose       bool
+	PriorityPaths []string
+	NodeTable     bool
+	Credentials   storage.ClipStorageCredentials
+	ProgressChan  chan<- int
 }
 
 type CreateRemoteOptions struct {
@@ -34,6 +37,7 @@ type ExtractOptions struct {
 	InputFile  string
 	OutputPath string
 	Verbose    bool
+	Tar        bool // Write a tar stream to OutputPath ("-" for stdout) instead of a directory
 }
 
 type MountOptions struct {
@@ -41,9 +45,29 @@ type MountOptions struct {
 	MountPoint            string
 	Verbose               bool
 	CachePath             string
+	CacheFileMode         os.FileMode
+	UseMmapCache          bool
+	Immutable             bool
+	EnableXattrs          bool
+	VerifyContentCache    bool
+	ContentCacheChunkSize int64
+	ReuseChunkBuffers     bool
+	ReadTimeout           time.Duration // Zero means no timeout for S3, and 60s for HTTP and service storage
 	ContentCache          clipfs.ContentCache
 	ContentCacheAvailable bool
 	Credentials           storage.ClipStorageCredentials
+
+	// Mount an archive served by "clip serve" instead of ArchivePath
+	ServiceAddress string
+	ServiceToken   string
+
+	// Kernel caching knobs, zero values use the defaults below. Since the filesystem
+	// is read-only, long timeouts only risk serving stale data if the archive is replaced.
+	AttrTimeout           time.Duration
+	EntryTimeout          time.Duration
+	MaxReadAhead          int
+	MaxBackground         int
+	DisableSymlinkCaching bool
 }
 
 type StoreS3Options struct {
@@ -52,8 +76,29 @@ type StoreS3Options struct {
 	Bucket       string
 	Key          string
 	CachePath    string
-	Credentials  storage.ClipStorageCredentials
-	ProgressChan chan<- int
+	SSEMode      string
+	KMSKeyID     string
+	StorageClass string
+	// Use the AWS default credential chain instead of static keys, both for the
+	// upload and whenever the resulting archive is mounted
+	UseDefaultCredentials bool
+	Credentials           storage.ClipStorageCredentials
+	ProgressChan          chan<- int
+}
+
+const (
+	defaultAttrTimeout   = time.Second * 60
+	defaultEntryTimeout  = time.Second * 60
+	defaultMaxReadAhead  = 1 << 17
+	defaultMaxBackground = 512
+
+	immutableCacheTimeout = time.Hour * 24
+)
+
+type StoreHTTPOptions struct {
+	ArchivePath string
+	OutputFile  string
+	URL         string
 }
 
 // Create Archive
@@ -63,9 +108,11 @@ func CreateArchive(options CreateOptions) error {
 
 	a := archive.NewClipArchiver()
 	err := a.Create(archive.ClipArchiverOptions{
-		SourcePath: options.InputPath,
-		OutputFile: options.OutputPath,
-		Verbose:    options.Verbose,
+		SourcePath:    options.InputPath,
+		OutputFile:    options.OutputPath,
+		Verbose:       options.Verbose,
+		PriorityPaths: options.PriorityPaths,
+		NodeTable:     options.NodeTable,
 	})
 	if err != nil {
 		return err
@@ -88,9 +135,11 @@ func CreateAndUploadArchive(ctx context.Context, options CreateOptions, si commo
 
 	localArchiver := archive.NewClipArchiver()
 	err = localArchiver.Create(archive.ClipArchiverOptions{
-		SourcePath: options.InputPath,
-		OutputFile: tempFile.Name(),
-		Verbose:    options.Verbose,
+		SourcePath:    options.InputPath,
+		OutputFile:    tempFile.Name(),
+		Verbose:       options.Verbose,
+		PriorityPaths: options.PriorityPaths,
+		NodeTable:     options.NodeTable,
 	})
 	if err != nil {
 		return err
@@ -116,6 +165,10 @@ func ExtractArchive(options ExtractOptions) error {
 	log.Printf("Extracting archive: %s\n", options.InputFile)
 
 	a := archive.NewClipArchiver()
+	if options.Tar {
+		return extractArchiveToTar(a, options)
+	}
+
 	err := a.Extract(archive.ClipArchiverOptions{
 		ArchivePath: options.InputFile,
 		OutputPath:  options.OutputPath,
@@ -130,66 +183,152 @@ func ExtractArchive(options ExtractOptions) error {
 	return nil
 }
 
-// Mount a clip archive to a directory
-func MountArchive(options MountOptions) (func() error, <-chan error, *fuse.Server, error) {
-	log.Printf("Mounting archive %s to %s\n", options.ArchivePath, options.MountPoint)
+func extractArchiveToTar(a *archive.ClipArchiver, options ExtractOptions) error {
+	var w io.Writer = os.Stdout
+	if options.OutputPath != "-" {
+		f, err := os.Create(options.OutputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err := a.ExtractToTar(options.InputFile, w)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Archive extracted successfully.")
+	return nil
+}
+
+// NewMount mounts a clip archive to a directory. The kernel mount is created
+// immediately, but no requests are answered until Serve is called.
+func NewMount(options MountOptions) (*Mount, error) {
+	source := options.ArchivePath
+	if options.ServiceAddress != "" {
+		source = options.ServiceAddress
+	}
+	log.Printf("Mounting archive %s to %s\n", source, options.MountPoint)
 
 	if _, err := os.Stat(options.MountPoint); os.IsNotExist(err) {
 		err = os.MkdirAll(options.MountPoint, 0755)
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to create mount point directory: %v", err)
+			return nil, fmt.Errorf("failed to create mount point directory: %v", err)
 		}
 		log.Println("Mount point directory created.")
 	}
 
-	ca := archive.NewClipArchiver()
-	metadata, err := ca.ExtractMetadata(options.ArchivePath)
+	s, err := newMountStorage(options)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("invalid archive: %v", err)
+		return nil, err
 	}
 
-	s, err := storage.NewClipStorage(options.ArchivePath, options.CachePath, metadata, options.Credentials)
+	clipfs, err := clipfs.NewFileSystem(s, clipfs.ClipFileSystemOpts{Verbose: options.Verbose, ContentCache: options.ContentCache, ContentCacheAvailable: options.ContentCacheAvailable, Immutable: options.Immutable, EnableXattrs: options.EnableXattrs, VerifyContentCache: options.VerifyContentCache, ContentCacheChunkSize: options.ContentCacheChunkSize, ReuseChunkBuffers: options.ReuseChunkBuffers})
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("could not load storage: %v", err)
+		s.Cleanup()
+		return nil, fmt.Errorf("could not create filesystem: %v", err)
 	}
 
-	clipfs, err := clipfs.NewFileSystem(s, clipfs.ClipFileSystemOpts{Verbose: options.Verbose, ContentCache: options.ContentCache, ContentCacheAvailable: options.ContentCacheAvailable})
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("could not create filesystem: %v", err)
+	root, _ := clipfs.Root()
+	attrTimeout := defaultAttrTimeout
+	entryTimeout := defaultEntryTimeout
+	if options.Immutable {
+		// Attributes of an immutable archive can't change, so cache them for much longer
+		attrTimeout = immutableCacheTimeout
+		entryTimeout = immutableCacheTimeout
+	}
+	if options.AttrTimeout > 0 {
+		attrTimeout = options.AttrTimeout
+	}
+	if options.EntryTimeout > 0 {
+		entryTimeout = options.EntryTimeout
+	}
+
+	maxReadAhead := defaultMaxReadAhead
+	if options.MaxReadAhead > 0 {
+		maxReadAhead = options.MaxReadAhead
+	}
+
+	maxBackground := defaultMaxBackground
+	if options.MaxBackground > 0 {
+		maxBackground = options.MaxBackground
 	}
 
-	root, _ := clipfs.Root()
-	attrTimeout := time.Second * 60
-	entryTimeout := time.Second * 60
 	fsOptions := &fs.Options{
 		AttrTimeout:  &attrTimeout,
 		EntryTimeout: &entryTimeout,
 	}
 	server, err := fuse.NewServer(fs.NewNodeFS(root, fsOptions), options.MountPoint, &fuse.MountOptions{
-		MaxBackground:        512,
-		DisableXAttrs:        true,
-		EnableSymlinkCaching: true,
+		MaxBackground:        maxBackground,
+		DisableXAttrs:        !options.EnableXattrs,
+		EnableSymlinkCaching: !options.DisableSymlinkCaching,
 		SyncRead:             false,
 		RememberInodes:       true,
-		MaxReadAhead:         1 << 17,
+		MaxReadAhead:         maxReadAhead,
 	})
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("could not create server: %v", err)
+		s.Cleanup()
+		return nil, fmt.Errorf("could not create server: %v", err)
+	}
+
+	return &Mount{server: server, storage: s, mountPoint: options.MountPoint}, nil
+}
+
+func newMountStorage(options MountOptions) (storage.ClipStorageInterface, error) {
+	if options.ServiceAddress != "" {
+		s, err := storage.NewServiceClipStorage(storage.ServiceClipStorageOpts{
+			Address:     options.ServiceAddress,
+			BearerToken: options.ServiceToken,
+			ReadTimeout: options.ReadTimeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to service: %v", err)
+		}
+		return s, nil
+	}
+
+	ca := archive.NewClipArchiver()
+	metadata, err := ca.ExtractMetadata(options.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %v", err)
+	}
+
+	s, err := storage.NewClipStorageWithOpts(storage.ClipStorageOpts{
+		ArchivePath:   options.ArchivePath,
+		CachePath:     options.CachePath,
+		CacheFileMode: options.CacheFileMode,
+		UseMmapCache:  options.UseMmapCache,
+		ReadTimeout:   options.ReadTimeout,
+		Metadata:      metadata,
+		Credentials:   options.Credentials,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load storage: %v", err)
+	}
+
+	return s, nil
+}
+
+// Mount a clip archive to a directory
+func MountArchive(options MountOptions) (func() error, <-chan error, *fuse.Server, error) {
+	m, err := NewMount(options)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	serverError := make(chan error, 1)
 	startServer := func() error {
 		go func() {
-			go server.Serve()
+			m.Serve()
 
-			if err := server.WaitMount(); err != nil {
+			if err := m.WaitMount(); err != nil {
 				serverError <- err
 				return
 			}
 
-			server.Wait()
-
-			s.Cleanup()
+			m.Wait()
 
 			close(serverError)
 		}()
@@ -197,7 +336,7 @@ func MountArchive(options MountOptions) (func() error, <-chan error, *fuse.Serve
 		return nil
 	}
 
-	return startServer, serverError, server, nil
+	return startServer, serverError, m.server, nil
 }
 
 // Store CLIP in remote storage
@@ -210,7 +349,15 @@ func StoreS3(storeS3Opts StoreS3Options) error {
 		storeS3Opts.Key = filepath.Base(storeS3Opts.ArchivePath)
 	}
 
-	storageInfo := &common.S3StorageInfo{Bucket: storeS3Opts.Bucket, Key: storeS3Opts.Key, Region: region}
+	storageInfo := &common.S3StorageInfo{
+		Bucket:                storeS3Opts.Bucket,
+		Key:                   storeS3Opts.Key,
+		Region:                region,
+		SSEMode:               storeS3Opts.SSEMode,
+		KMSKeyID:              storeS3Opts.KMSKeyID,
+		StorageClass:          storeS3Opts.StorageClass,
+		UseDefaultCredentials: storeS3Opts.UseDefaultCredentials,
+	}
 	a, err := archive.NewRClipArchiver(storageInfo)
 	if err != nil {
 		return err
@@ -224,3 +371,21 @@ func StoreS3(storeS3Opts StoreS3Options) error {
 	log.Println("Done uploading.")
 	return nil
 }
+
+// Create an RCLIP backed by a CLIP archive hosted at an http(s) URL. The archive
+// itself must be uploaded separately, to a server that supports range requests.
+func StoreHTTP(storeHTTPOpts StoreHTTPOptions) error {
+	storageInfo := &common.HTTPStorageInfo{URL: storeHTTPOpts.URL}
+	a, err := archive.NewRClipArchiver(storageInfo)
+	if err != nil {
+		return err
+	}
+
+	err = a.Create(context.TODO(), storeHTTPOpts.ArchivePath, storeHTTPOpts.OutputFile, storage.ClipStorageCredentials{}, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Done.")
+	return nil
+}
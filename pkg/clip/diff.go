@@ -0,0 +1,121 @@
+package clip
+
+import (
+	"fmt"
+
+	"github.com/beam-cloud/clip/pkg/archive"
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+type DiffOptions struct {
+	ArchivePathA string
+	ArchivePathB string
+}
+
+type DiffEntry struct {
+	Path    string
+	Change  string
+	Details []string // What changed, only set for modified paths
+}
+
+type DiffResult struct {
+	Entries  []DiffEntry
+	Added    int
+	Removed  int
+	Modified int
+}
+
+// DiffArchives compares the indexes of two archives and reports the paths that were added to,
+// removed from or modified in the second archive, in path order
+func DiffArchives(options DiffOptions) (*DiffResult, error) {
+	ca := archive.NewClipArchiver()
+
+	metadataA, err := ca.ExtractMetadata(options.ArchivePathA)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive %s: %v", options.ArchivePathA, err)
+	}
+
+	metadataB, err := ca.ExtractMetadata(options.ArchivePathB)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive %s: %v", options.ArchivePathB, err)
+	}
+
+	nodesA := sortedNodes(metadataA)
+	nodesB := sortedNodes(metadataB)
+	result := &DiffResult{}
+
+	// Both node lists are sorted by path, so walk them together
+	i, j := 0, 0
+	for i < len(nodesA) || j < len(nodesB) {
+		switch {
+		case j == len(nodesB) || (i < len(nodesA) && nodesA[i].Path < nodesB[j].Path):
+			result.Entries = append(result.Entries, DiffEntry{Path: nodesA[i].Path, Change: DiffRemoved})
+			result.Removed++
+			i++
+		case i == len(nodesA) || nodesB[j].Path < nodesA[i].Path:
+			result.Entries = append(result.Entries, DiffEntry{Path: nodesB[j].Path, Change: DiffAdded})
+			result.Added++
+			j++
+		default:
+			if details := diffNodes(nodesA[i], nodesB[j]); len(details) > 0 {
+				result.Entries = append(result.Entries, DiffEntry{Path: nodesA[i].Path, Change: DiffModified, Details: details})
+				result.Modified++
+			}
+			i++
+			j++
+		}
+	}
+
+	return result, nil
+}
+
+func sortedNodes(metadata *common.ClipArchiveMetadata) []*common.ClipNode {
+	var nodes []*common.ClipNode
+	metadata.Index.Ascend(metadata.Index.Min(), func(a interface{}) bool {
+		nodes = append(nodes, a.(*common.ClipNode))
+		return true
+	})
+	return nodes
+}
+
+// diffNodes describes the differences between two nodes at the same path
+func diffNodes(a, b *common.ClipNode) []string {
+	var details []string
+
+	if a.NodeType != b.NodeType {
+		details = append(details, fmt.Sprintf("type %s -> %s", a.NodeType, b.NodeType))
+	}
+	if a.Attr.Size != b.Attr.Size {
+		details = append(details, fmt.Sprintf("size %d -> %d", a.Attr.Size, b.Attr.Size))
+	}
+	if a.Attr.Mode&07777 != b.Attr.Mode&07777 {
+		details = append(details, fmt.Sprintf("mode %04o -> %04o", a.Attr.Mode&07777, b.Attr.Mode&07777))
+	}
+	if a.Attr.Owner != b.Attr.Owner {
+		details = append(details, fmt.Sprintf("owner %d:%d -> %d:%d", a.Attr.Owner.Uid, a.Attr.Owner.Gid, b.Attr.Owner.Uid, b.Attr.Owner.Gid))
+	}
+	if a.ContentHash != b.ContentHash {
+		details = append(details, fmt.Sprintf("content %s -> %s", shortHash(a.ContentHash), shortHash(b.ContentHash)))
+	}
+	if a.Target != b.Target {
+		details = append(details, fmt.Sprintf("target %s -> %s", a.Target, b.Target))
+	}
+
+	return details
+}
+
+func shortHash(hash string) string {
+	if hash == "" {
+		return "-"
+	}
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
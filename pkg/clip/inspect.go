@@ -0,0 +1,84 @@
+package clip
+
+import (
+	"fmt"
+
+	"github.com/beam-cloud/clip/pkg/archive"
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+type InspectOptions struct {
+	ArchivePath string
+}
+
+type ArchiveInfo struct {
+	FormatVersion     uint8                  `json:"format_version"`
+	IndexPos          int64                  `json:"index_pos"`
+	IndexLength       int64                  `json:"index_length"`
+	StorageInfoPos    int64                  `json:"storage_info_pos"`
+	StorageInfoLength int64                  `json:"storage_info_length"`
+	MetadataChecksum  uint64                 `json:"metadata_checksum"`
+	NodeTablePos      int64                  `json:"node_table_pos"`
+	NodeTableLength   int64                  `json:"node_table_length"`
+	StorageType       string                 `json:"storage_type"`
+	StorageInfo       common.ClipStorageInfo `json:"storage_info,omitempty"`
+	Nodes             int                    `json:"nodes"`
+	Files             int                    `json:"files"`
+	Directories       int                    `json:"directories"`
+	Symlinks          int                    `json:"symlinks"`
+	DataSize          int64                  `json:"data_size"`        // Logical size, the sum of every file's size
+	StoredDataSize    int64                  `json:"stored_data_size"` // Bytes actually stored, files with identical content share data
+}
+
+// Inspect an archive's metadata without mounting or extracting it
+func InspectArchive(options InspectOptions) (*ArchiveInfo, error) {
+	ca := archive.NewClipArchiver()
+	metadata, err := ca.ExtractMetadata(options.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %v", err)
+	}
+
+	header := metadata.Header
+	info := &ArchiveInfo{
+		FormatVersion:     header.ClipFileFormatVersion,
+		IndexPos:          header.IndexPos,
+		IndexLength:       header.IndexLength,
+		StorageInfoPos:    header.StorageInfoPos,
+		StorageInfoLength: header.StorageInfoLength,
+		MetadataChecksum:  header.MetadataChecksum,
+		NodeTablePos:      header.NodeTablePos,
+		NodeTableLength:   header.NodeTableLength,
+		StorageType:       "local",
+		StorageInfo:       metadata.StorageInfo,
+	}
+
+	if metadata.StorageInfo != nil {
+		info.StorageType = metadata.StorageInfo.Type()
+	}
+
+	// Deduplicated files point at the same data, so only count each position once
+	stored := make(map[int64]bool)
+
+	metadata.Index.Ascend(metadata.Index.Min(), func(a interface{}) bool {
+		node := a.(*common.ClipNode)
+		info.Nodes++
+
+		switch node.NodeType {
+		case common.FileNode:
+			info.Files++
+			info.DataSize += node.DataLen
+			if !stored[node.DataPos] {
+				stored[node.DataPos] = true
+				info.StoredDataSize += node.DataLen
+			}
+		case common.DirNode:
+			info.Directories++
+		case common.SymLinkNode:
+			info.Symlinks++
+		}
+
+		return true
+	})
+
+	return info, nil
+}
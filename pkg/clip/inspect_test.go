@@ -0,0 +1,49 @@
+package clip
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectArchiveDataSize(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "src")
+
+	files := map[string]string{
+		"a.txt":     "duplicated",
+		"b.txt":     "duplicated",
+		"dir/c.txt": "duplicated",
+		"d.txt":     "unique",
+		"empty":     "",
+	}
+	for path, content := range files {
+		path = filepath.Join(sourcePath, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePath := filepath.Join(dir, "test.clip")
+	if err := CreateArchive(CreateOptions{InputPath: sourcePath, OutputPath: archivePath}); err != nil {
+		t.Fatalf("unable to create archive: %v", err)
+	}
+
+	info, err := InspectArchive(InspectOptions{ArchivePath: archivePath})
+	if err != nil {
+		t.Fatalf("unable to inspect archive: %v", err)
+	}
+
+	if info.Files != len(files) {
+		t.Errorf("expected %d files, got %d", len(files), info.Files)
+	}
+	if want := int64(3*len("duplicated") + len("unique")); info.DataSize != want {
+		t.Errorf("expected a logical size of %d, got %d", want, info.DataSize)
+	}
+	if want := int64(len("duplicated") + len("unique")); info.StoredDataSize != want {
+		t.Errorf("expected a stored size of %d, got %d", want, info.StoredDataSize)
+	}
+}
@@ -0,0 +1,51 @@
+package clip
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/beam-cloud/clip/pkg/archive"
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+type ListOptions struct {
+	ArchivePath string
+	Path        string // If set, only list the immediate children of this directory
+}
+
+// List the nodes of an archive in path order without mounting it
+func ListArchive(options ListOptions) ([]*common.ClipNode, error) {
+	ca := archive.NewClipArchiver()
+	metadata, err := ca.ExtractMetadata(options.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %v", err)
+	}
+
+	var nodes []*common.ClipNode
+
+	if options.Path == "" {
+		metadata.Index.Ascend(metadata.Index.Min(), func(a interface{}) bool {
+			nodes = append(nodes, a.(*common.ClipNode))
+			return true
+		})
+		return nodes, nil
+	}
+
+	dirPath := path.Clean("/" + options.Path)
+	dir := metadata.Get(dirPath)
+	if dir == nil {
+		return nil, fmt.Errorf("path not found in archive: %s", dirPath)
+	}
+
+	if !dir.IsDir() {
+		return []*common.ClipNode{dir}, nil
+	}
+
+	for _, entry := range metadata.ListDirectory(dirPath) {
+		if node := metadata.Get(path.Join(dirPath, entry.Name)); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes, nil
+}
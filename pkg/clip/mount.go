@@ -0,0 +1,64 @@
+package clip
+
+import (
+	"sync"
+
+	"github.com/beam-cloud/clip/pkg/storage"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Mount is a mounted clip archive. The lifecycle is:
+//
+//	m, err := NewMount(options) // create the kernel mount
+//	m.Serve()                   // start answering filesystem requests
+//	m.WaitMount()               // block until the mount is usable
+//	...
+//	m.Unmount()                 // unmount and release the archive storage
+//
+// Wait can be used instead of Unmount to block until the filesystem is unmounted
+// externally (e.g. with fusermount -u).
+type Mount struct {
+	server     *fuse.Server
+	storage    storage.ClipStorageInterface
+	mountPoint string
+
+	cleanupOnce sync.Once
+	cleanupErr  error
+}
+
+// Serve starts answering filesystem requests in the background
+func (m *Mount) Serve() {
+	go m.server.Serve()
+}
+
+// WaitMount blocks until the kernel has finished setting up the mount
+func (m *Mount) WaitMount() error {
+	return m.server.WaitMount()
+}
+
+// Wait blocks until the filesystem is unmounted, then cleans up the storage
+func (m *Mount) Wait() error {
+	m.server.Wait()
+	return m.cleanup()
+}
+
+// Unmount unmounts the filesystem and cleans up the storage. It is safe to call
+// more than once.
+func (m *Mount) Unmount() error {
+	if err := m.server.Unmount(); err != nil {
+		return err
+	}
+	return m.cleanup()
+}
+
+// MountPath returns the directory the archive is mounted on
+func (m *Mount) MountPath() string {
+	return m.mountPoint
+}
+
+func (m *Mount) cleanup() error {
+	m.cleanupOnce.Do(func() {
+		m.cleanupErr = m.storage.Cleanup()
+	})
+	return m.cleanupErr
+}
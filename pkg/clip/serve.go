@@ -0,0 +1,59 @@
+package clip
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/archive"
+	"github.com/beam-cloud/clip/pkg/storage"
+)
+
+type ServeOptions struct {
+	ArchivePath string
+	Address     string
+	CachePath   string
+	BearerToken string
+	Credentials storage.ClipStorageCredentials
+}
+
+// Requests are small GETs, but responses can be a large index or a read that misses the cache
+const (
+	serveReadTimeout  = time.Second * 30
+	serveWriteTimeout = time.Minute * 5
+	serveIdleTimeout  = time.Minute * 2
+)
+
+// Serve an archive's metadata and file content over HTTP, so multiple hosts can share
+// one warmed cache. Clients connect with storage.NewServiceClipStorage.
+func ServeArchive(options ServeOptions) error {
+	ca := archive.NewClipArchiver()
+	metadata, err := ca.ExtractMetadata(options.ArchivePath)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %v", err)
+	}
+
+	s, err := storage.NewClipStorageWithOpts(storage.ClipStorageOpts{
+		ArchivePath: options.ArchivePath,
+		CachePath:   options.CachePath,
+		Metadata:    metadata,
+		Credentials: options.Credentials,
+	})
+	if err != nil {
+		return fmt.Errorf("could not load storage: %v", err)
+	}
+	defer s.Cleanup()
+
+	log.Printf("Serving archive %s on %s\n", options.ArchivePath, options.Address)
+	server := &http.Server{
+		Addr:              options.Address,
+		Handler:           storage.NewServiceHandler(s, options.BearerToken),
+		ReadHeaderTimeout: serveReadTimeout,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+
+	return server.ListenAndServe()
+}
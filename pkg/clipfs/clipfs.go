@@ -1,6 +1,7 @@
 package clipfs
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -14,8 +15,15 @@ type ClipFileSystemOpts struct {
 	Verbose               bool
 	ContentCache          ContentCache
 	ContentCacheAvailable bool
+	Immutable             bool  // Archive content never changes, so the kernel page cache can be kept across opens
+	EnableXattrs          bool  // Serve extended attributes stored in the index
+	VerifyContentCache    bool  // Check content cache reads against the index and fall back to storage on mismatch
+	ContentCacheChunkSize int64 // Size of the chunks files are sent to the content cache in, defaults to 32Mb
+	ReuseChunkBuffers     bool  // Recycle chunk buffers between stores, see ContentCache
 }
 
+const defaultContentCacheChunkSize = int64(1 << 25) // 32Mb
+
 type ClipFileSystem struct {
 	s                     storage.ClipStorageInterface
 	root                  *FSNode
@@ -24,6 +32,12 @@ type ClipFileSystem struct {
 	contentCacheAvailable bool
 	cacheMutex            sync.RWMutex
 	verbose               bool
+	immutable             bool
+	enableXattrs          bool
+	verifyContentCache    bool
+	contentCacheChunkSize int64
+	reuseChunkBuffers     bool
+	chunkPool             sync.Pool
 	cachingStatus         map[string]bool
 	cacheEventChan        chan cacheEvent
 	cachingStatusMu       sync.Mutex
@@ -34,6 +48,9 @@ type lookupCacheEntry struct {
 	attr  fuse.Attr
 }
 
+// ContentCache stores file content by hash. StoreContent receives a file as a sequence of
+// chunks. If ClipFileSystemOpts.ReuseChunkBuffers is set chunk buffers are recycled, so a
+// chunk must not be retained once the next one has been received or StoreContent has returned.
 type ContentCache interface {
 	GetContent(hash string, offset int64, length int64) ([]byte, error)
 	StoreContent(chan []byte) (string, error)
@@ -43,10 +60,83 @@ type cacheEvent struct {
 	node *FSNode
 }
 
+// pendingCacheStores tracks content cache stores that have been queued but not yet completed
+var pendingCacheStores = newStoreTracker()
+
+// storeTracker counts pending stores. Unlike a sync.WaitGroup it is safe to add to
+// while another goroutine is waiting.
+type storeTracker struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending int
+}
+
+func newStoreTracker() *storeTracker {
+	t := &storeTracker{}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+func (t *storeTracker) add() {
+	t.mu.Lock()
+	t.pending++
+	t.mu.Unlock()
+}
+
+func (t *storeTracker) done() {
+	t.mu.Lock()
+	t.pending--
+	if t.pending <= 0 {
+		t.cond.Broadcast()
+	}
+	t.mu.Unlock()
+}
+
+// wait blocks until there are no pending stores or ctx is done
+func (t *storeTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.mu.Lock()
+		for t.pending > 0 && ctx.Err() == nil {
+			t.cond.Wait()
+		}
+		t.mu.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// Wake the waiter so it sees the cancelled context and exits
+		t.mu.Lock()
+		t.cond.Broadcast()
+		t.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// FlushContentCacheStores blocks until all pending content cache stores have completed,
+// or returns the context error if ctx is done first. Callers should invoke this before
+// exiting so in-flight stores are not lost.
+func FlushContentCacheStores(ctx context.Context) error {
+	return pendingCacheStores.wait(ctx)
+}
+
 func NewFileSystem(s storage.ClipStorageInterface, opts ClipFileSystemOpts) (*ClipFileSystem, error) {
+	contentCacheChunkSize := opts.ContentCacheChunkSize
+	if contentCacheChunkSize <= 0 {
+		contentCacheChunkSize = defaultContentCacheChunkSize
+	}
+
 	cfs := &ClipFileSystem{
 		s:                     s,
 		verbose:               opts.Verbose,
+		immutable:             opts.Immutable,
+		enableXattrs:          opts.EnableXattrs,
+		verifyContentCache:    opts.VerifyContentCache,
+		contentCacheChunkSize: contentCacheChunkSize,
+		reuseChunkBuffers:     opts.ReuseChunkBuffers,
 		lookupCache:           make(map[string]*lookupCacheEntry),
 		contentCache:          opts.ContentCache,
 		cacheEventChan:        make(chan cacheEvent, 10000),
@@ -79,18 +169,31 @@ func (cfs *ClipFileSystem) Root() (fs.InodeEmbedder, error) {
 }
 
 func (cfs *ClipFileSystem) CacheFile(node *FSNode) {
+	if cfs.queueCacheStore(node) {
+		cfs.submitCacheStore(node)
+	}
+}
+
+// queueCacheStore marks the node's content as being cached and counts the pending
+// store. It returns false if the content is already being cached or has been cached.
+func (cfs *ClipFileSystem) queueCacheStore(node *FSNode) bool {
 	hash := node.clipNode.ContentHash
 
-	// Check and update caching status
 	cfs.cachingStatusMu.Lock()
+	defer cfs.cachingStatusMu.Unlock()
+
 	if cfs.cachingStatus[hash] {
-		cfs.cachingStatusMu.Unlock()
-		return // File is already being cached or has been cached
+		return false
 	}
 	cfs.cachingStatus[hash] = true
-	cfs.cachingStatusMu.Unlock()
+	pendingCacheStores.add()
+
+	return true
+}
 
-	// Submit cache event
+// submitCacheStore hands a store queued with queueCacheStore to the cache worker. It
+// blocks if the worker is backed up.
+func (cfs *ClipFileSystem) submitCacheStore(node *FSNode) {
 	cfs.cacheEventChan <- cacheEvent{node: node}
 }
 
@@ -102,42 +205,84 @@ func (cfs *ClipFileSystem) clearCachingStatus(hash string) {
 
 func (cfs *ClipFileSystem) processCacheEvents() {
 	for cacheEvent := range cfs.cacheEventChan {
-		clipNode := cacheEvent.node.clipNode
+		cfs.storeContent(cacheEvent)
+		pendingCacheStores.done()
+	}
+}
 
-		if clipNode.DataLen > 0 {
-			chunks := make(chan []byte, 1)
+func (cfs *ClipFileSystem) storeContent(cacheEvent cacheEvent) {
+	clipNode := cacheEvent.node.clipNode
 
-			go func(chunks chan []byte) {
-				chunkSize := int64(1 << 25) // 32Mb
+	if clipNode.DataLen > 0 {
+		// Unbuffered, so once a chunk is received the store is done with the one before it,
+		// and at most two chunk buffers are in use per store
+		chunks := make(chan []byte)
+		stop := make(chan struct{})
+		lastChunk := make(chan []byte, 1)
 
-				if chunkSize > clipNode.DataLen {
-					chunkSize = clipNode.DataLen
-				}
+		go func() {
+			var previous []byte
+			defer func() {
+				close(chunks)
+				lastChunk <- previous
+			}()
 
-				for offset := int64(0); offset < clipNode.DataLen; offset += int64(chunkSize) {
-					if (clipNode.DataLen - offset) < chunkSize {
-						chunkSize = clipNode.DataLen - offset
-					}
+			chunkSize := cfs.contentCacheChunkSize
+			if chunkSize > clipNode.DataLen {
+				chunkSize = clipNode.DataLen
+			}
 
-					fileContent := make([]byte, chunkSize) // Create a new buffer for each chunk
-					nRead, err := cfs.s.ReadFile(clipNode, fileContent, offset)
-					if err != nil {
-						cacheEvent.node.log("err reading file: %v", err)
-						break
-					}
+			for offset := int64(0); offset < clipNode.DataLen; offset += chunkSize {
+				fileContent := cfs.getChunkBuffer(chunkSize)
+				if remaining := clipNode.DataLen - offset; remaining < chunkSize {
+					fileContent = fileContent[:remaining]
+				}
 
-					chunks <- fileContent[:nRead]
-					fileContent = nil
+				nRead, err := cfs.s.ReadFile(clipNode, fileContent, offset)
+				if err != nil {
+					cacheEvent.node.log("err reading file: %v", err)
+					cfs.putChunkBuffer(fileContent)
+					return
 				}
 
-				close(chunks)
-			}(chunks)
+				select {
+				case chunks <- fileContent[:nRead]:
+				case <-stop:
+					cfs.putChunkBuffer(fileContent)
+					return
+				}
 
-			hash, err := cfs.contentCache.StoreContent(chunks)
-			if err != nil || hash != clipNode.ContentHash {
-				cacheEvent.node.log("err storing file contents: %v", err)
-				cfs.clearCachingStatus(clipNode.ContentHash)
+				cfs.putChunkBuffer(previous)
+				previous = fileContent
 			}
+		}()
+
+		hash, err := cfs.contentCache.StoreContent(chunks)
+		close(stop)
+		cfs.putChunkBuffer(<-lastChunk)
+
+		if err != nil || hash != clipNode.ContentHash {
+			cacheEvent.node.log("err storing file contents: %v", err)
+			cfs.clearCachingStatus(clipNode.ContentHash)
 		}
 	}
 }
+
+// getChunkBuffer returns a buffer of size bytes. When reusing chunk buffers, full size
+// buffers come from a pool so storing large files doesn't allocate a new buffer per chunk.
+func (cfs *ClipFileSystem) getChunkBuffer(size int64) []byte {
+	if !cfs.reuseChunkBuffers || size != cfs.contentCacheChunkSize {
+		return make([]byte, size)
+	}
+
+	if buf, ok := cfs.chunkPool.Get().([]byte); ok {
+		return buf[:size]
+	}
+	return make([]byte, size)
+}
+
+func (cfs *ClipFileSystem) putChunkBuffer(buf []byte) {
+	if cfs.reuseChunkBuffers && int64(cap(buf)) == cfs.contentCacheChunkSize {
+		cfs.chunkPool.Put(buf[:cap(buf)])
+	}
+}
@@ -0,0 +1,335 @@
+package clipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/common"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/tidwall/btree"
+)
+
+// memStorage serves file content from memory and never reports itself as cached
+// locally, so reads go through the content cache
+type memStorage struct {
+	metadata *common.ClipArchiveMetadata
+	content  map[string][]byte
+}
+
+func newMemStorage(files map[string][]byte) *memStorage {
+	index := btree.New(func(a, b interface{}) bool {
+		return a.(*common.ClipNode).Path < b.(*common.ClipNode).Path
+	})
+	index.Set(&common.ClipNode{Path: "/", NodeType: common.DirNode, Attr: fuse.Attr{Mode: fuse.S_IFDIR | 0755}})
+
+	s := &memStorage{metadata: &common.ClipArchiveMetadata{Index: index}, content: make(map[string][]byte)}
+	for path, data := range files {
+		hash := sha256.Sum256(data)
+		node := &common.ClipNode{
+			Path:        path,
+			NodeType:    common.FileNode,
+			DataLen:     int64(len(data)),
+			ContentHash: hex.EncodeToString(hash[:]),
+			Attr:        fuse.Attr{Mode: fuse.S_IFREG | 0644, Size: uint64(len(data))},
+		}
+		index.Set(node)
+		s.content[path] = data
+	}
+
+	return s
+}
+
+func (s *memStorage) ReadFile(node *common.ClipNode, dest []byte, off int64) (int, error) {
+	data := s.content[node.Path]
+	if off >= int64(len(data)) {
+		return 0, nil
+	}
+	return copy(dest, data[off:]), nil
+}
+
+func (s *memStorage) Metadata() *common.ClipArchiveMetadata { return s.metadata }
+func (s *memStorage) CachedLocally() bool                   { return false }
+func (s *memStorage) Cleanup() error                        { return nil }
+
+// memContentCache stores content in memory, optionally taking delay to store each file
+type memContentCache struct {
+	mu      sync.Mutex
+	delay   time.Duration
+	content map[string][]byte
+}
+
+func newMemContentCache(delay time.Duration) *memContentCache {
+	return &memContentCache{delay: delay, content: make(map[string][]byte)}
+}
+
+func (c *memContentCache) GetContent(hash string, offset int64, length int64) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, ok := c.content[hash]
+	if !ok {
+		return nil, errors.New("content not found")
+	}
+	if offset >= int64(len(data)) {
+		return nil, nil
+	}
+	end := offset + length
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return data[offset:end], nil
+}
+
+func (c *memContentCache) StoreContent(chunks chan []byte) (string, error) {
+	var buf bytes.Buffer
+	for chunk := range chunks {
+		buf.Write(chunk)
+	}
+	time.Sleep(c.delay)
+
+	hash := sha256.Sum256(buf.Bytes())
+	hashStr := hex.EncodeToString(hash[:])
+
+	c.mu.Lock()
+	c.content[hashStr] = buf.Bytes()
+	c.mu.Unlock()
+
+	return hashStr, nil
+}
+
+func (c *memContentCache) stored(hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.content[hash]
+	return ok
+}
+
+func newTestFileSystem(t *testing.T, files map[string][]byte, cache *memContentCache) *ClipFileSystem {
+	t.Helper()
+
+	cfs, err := NewFileSystem(newMemStorage(files), ClipFileSystemOpts{
+		ContentCache:          cache,
+		ContentCacheAvailable: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to create filesystem: %v", err)
+	}
+
+	return cfs
+}
+
+func testNode(cfs *ClipFileSystem, path string) *FSNode {
+	clipNode := cfs.s.Metadata().Get(path)
+	return &FSNode{filesystem: cfs, clipNode: clipNode, attr: clipNode.Attr}
+}
+
+func TestFlushWaitsForStoreQueuedByRead(t *testing.T) {
+	cache := newMemContentCache(50 * time.Millisecond)
+	cfs := newTestFileSystem(t, map[string][]byte{"/file": []byte("hello world")}, cache)
+	node := testNode(cfs, "/file")
+
+	dest := make([]byte, 5)
+	if _, errno := node.Read(context.Background(), nil, dest, 0); errno != 0 {
+		t.Fatalf("read failed: %v", errno)
+	}
+
+	// The read returned, so the store must already be counted
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := FlushContentCacheStores(ctx); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	if !cache.stored(node.clipNode.ContentHash) {
+		t.Fatalf("flush returned before the store completed")
+	}
+}
+
+func TestFlushWhileQueueingStores(t *testing.T) {
+	files := make(map[string][]byte)
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("/file-%d", i)] = []byte(fmt.Sprintf("content %d", i))
+	}
+
+	cache := newMemContentCache(0)
+	cfs := newTestFileSystem(t, files, cache)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Queue stores while other goroutines flush, which a sync.WaitGroup doesn't allow
+	var wg sync.WaitGroup
+	for path := range files {
+		node := testNode(cfs, path)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cfs.CacheFile(node)
+		}()
+		go func() {
+			defer wg.Done()
+			if err := FlushContentCacheStores(ctx); err != nil {
+				t.Errorf("flush failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := FlushContentCacheStores(ctx); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	for path := range files {
+		if hash := cfs.s.Metadata().Get(path).ContentHash; !cache.stored(hash) {
+			t.Errorf("content of %s was not stored", path)
+		}
+	}
+}
+
+func TestFlushContextDone(t *testing.T) {
+	tracker := newStoreTracker()
+	tracker.add()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := tracker.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	tracker.done()
+	if err := tracker.wait(context.Background()); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+}
+
+// retainingContentCache keeps every chunk it receives without copying it
+type retainingContentCache struct {
+	chunks [][]byte
+}
+
+func (c *retainingContentCache) GetContent(hash string, offset int64, length int64) ([]byte, error) {
+	return nil, errors.New("content not found")
+}
+
+func (c *retainingContentCache) StoreContent(chunks chan []byte) (string, error) {
+	h := sha256.New()
+	for chunk := range chunks {
+		h.Write(chunk)
+		c.chunks = append(c.chunks, chunk)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashingContentCache hashes the content it receives and stores nothing
+type hashingContentCache struct{}
+
+func (hashingContentCache) GetContent(hash string, offset int64, length int64) ([]byte, error) {
+	return nil, errors.New("content not found")
+}
+
+func (hashingContentCache) StoreContent(chunks chan []byte) (string, error) {
+	h := sha256.New()
+	for chunk := range chunks {
+		h.Write(chunk)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func newChunkedFileSystem(tb testing.TB, data []byte, cache ContentCache, reuse bool) *ClipFileSystem {
+	tb.Helper()
+
+	cfs, err := NewFileSystem(newMemStorage(map[string][]byte{"/file": data}), ClipFileSystemOpts{
+		ContentCache:          cache,
+		ContentCacheAvailable: true,
+		ContentCacheChunkSize: 1 << 16,
+		ReuseChunkBuffers:     reuse,
+	})
+	if err != nil {
+		tb.Fatalf("unable to create filesystem: %v", err)
+	}
+
+	return cfs
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestStoreContentChunksNotReusedByDefault(t *testing.T) {
+	data := testData(1<<20 + 123)
+	cache := &retainingContentCache{}
+	cfs := newChunkedFileSystem(t, data, cache, false)
+
+	cfs.storeContent(cacheEvent{node: testNode(cfs, "/file")})
+
+	// Retained chunks must still hold the file content after the store
+	if got := bytes.Join(cache.chunks, nil); !bytes.Equal(got, data) {
+		t.Fatalf("retained chunks don't match the file content")
+	}
+}
+
+func TestStoreContentReuseChunkBuffersAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool doesn't reliably reuse buffers with the race detector")
+	}
+
+	data := testData(1 << 22)
+
+	allocated := func(reuse bool) uint64 {
+		cfs := newChunkedFileSystem(t, data, hashingContentCache{}, reuse)
+		node := testNode(cfs, "/file")
+
+		// Warm up the pool before measuring
+		cfs.storeContent(cacheEvent{node: node})
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for i := 0; i < 4; i++ {
+			cfs.storeContent(cacheEvent{node: node})
+		}
+		runtime.ReadMemStats(&after)
+
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	fresh := allocated(false)
+	reused := allocated(true)
+
+	// Without reuse every store allocates the whole file in chunks
+	if fresh < 4*uint64(len(data)) {
+		t.Fatalf("expected at least %d bytes allocated without reuse, got %d", 4*len(data), fresh)
+	}
+	if reused*4 > fresh {
+		t.Fatalf("expected reusing chunk buffers to allocate far less than %d bytes, got %d", fresh, reused)
+	}
+}
+
+func BenchmarkStoreContent(b *testing.B) {
+	data := testData(1 << 24)
+
+	for _, reuse := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reuse=%v", reuse), func(b *testing.B) {
+			cfs := newChunkedFileSystem(b, data, hashingContentCache{}, reuse)
+			node := testNode(cfs, "/file")
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				cfs.storeContent(cacheEvent{node: node})
+			}
+		})
+	}
+}
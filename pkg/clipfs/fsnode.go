@@ -2,9 +2,14 @@ package clipfs
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"path"
+	"sort"
 	"syscall"
 
 	"github.com/beam-cloud/clip/pkg/common"
@@ -92,6 +97,11 @@ func (n *FSNode) Opendir(ctx context.Context) syscall.Errno {
 
 func (n *FSNode) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	n.log("Open called with flags: %v", flags)
+
+	if n.filesystem.immutable {
+		return nil, fuse.FOPEN_KEEP_CACHE, fs.OK
+	}
+
 	return nil, 0, fs.OK
 }
 
@@ -111,6 +121,11 @@ func (n *FSNode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int
 	// Switch back local filesystem if all content is cached on disk
 	if n.filesystem.contentCacheAvailable && n.clipNode.ContentHash != "" && !n.filesystem.s.CachedLocally() {
 		content, err := n.filesystem.contentCache.GetContent(n.clipNode.ContentHash, off, length)
+		if err == nil && n.filesystem.verifyContentCache {
+			if err = n.verifyCachedContent(content, off, length); err != nil {
+				n.log("Content cache verification failed: %v", err)
+			}
+		}
 
 		// Content found in cache
 		if err == nil {
@@ -119,13 +134,15 @@ func (n *FSNode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int
 		} else { // Cache miss - read from the underlying source and store in cache
 			nRead, err := n.filesystem.s.ReadFile(n.clipNode, dest, off)
 			if err != nil {
-				return nil, syscall.EIO
+				n.log("Read failed: %v", err)
+				return nil, readErrno(err)
 			}
 
-			// Store entire file in CAS
-			go func() {
-				n.filesystem.CacheFile(n)
-			}()
+			// Store entire file in CAS. The store is counted before returning so a flush
+			// right after this read waits for it.
+			if n.filesystem.queueCacheStore(n) {
+				go n.filesystem.submitCacheStore(n)
+			}
 
 			return fuse.ReadResultData(dest[:nRead]), fs.OK
 		}
@@ -133,12 +150,54 @@ func (n *FSNode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int
 
 	nRead, err := n.filesystem.s.ReadFile(n.clipNode, dest, off)
 	if err != nil {
-		return nil, syscall.EIO
+		n.log("Read failed: %v", err)
+		return nil, readErrno(err)
 	}
 
 	return fuse.ReadResultData(dest[:nRead]), fs.OK
 }
 
+// verifyCachedContent checks content returned by the content cache against the index.
+// The length is always checked, and reads that cover the whole file are hashed and
+// compared with the node's content hash.
+func (n *FSNode) verifyCachedContent(content []byte, off int64, length int64) error {
+	expected := n.clipNode.DataLen - off
+	if expected < 0 {
+		expected = 0
+	}
+	if length < expected {
+		expected = length
+	}
+
+	if int64(len(content)) != expected {
+		return fmt.Errorf("got %d bytes at offset %d, expected %d", len(content), off, expected)
+	}
+
+	if off == 0 && expected == n.clipNode.DataLen {
+		hash := sha256.Sum256(content)
+		if hex.EncodeToString(hash[:]) != n.clipNode.ContentHash {
+			return fmt.Errorf("content hash mismatch for %s", n.clipNode.ContentHash)
+		}
+	}
+
+	return nil
+}
+
+// readErrno maps a storage read error to the errno returned to the kernel.
+// Anything unclassified is reported as a generic I/O error.
+func readErrno(err error) syscall.Errno {
+	switch {
+	case errors.Is(err, common.ErrStorageNotFound), errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, common.ErrStorageAccessDenied), errors.Is(err, os.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, common.ErrStorageTimeout), errors.Is(err, context.DeadlineExceeded):
+		return syscall.ETIMEDOUT
+	default:
+		return syscall.EIO
+	}
+}
+
 func (n *FSNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
 	n.log("Readlink called")
 
@@ -154,6 +213,55 @@ func (n *FSNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
 	return []byte(symlinkTarget), fs.OK
 }
 
+func (n *FSNode) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	n.log("Getxattr called with attr: %s", attr)
+
+	if !n.filesystem.enableXattrs {
+		return 0, syscall.ENOTSUP
+	}
+
+	value, ok := n.clipNode.Xattrs[attr]
+	if !ok {
+		return 0, syscall.ENODATA
+	}
+
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+
+	return uint32(copy(dest, value)), fs.OK
+}
+
+func (n *FSNode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	n.log("Listxattr called")
+
+	if !n.filesystem.enableXattrs {
+		return 0, fs.OK
+	}
+
+	// Attribute names are returned null terminated
+	names := make([]string, 0, len(n.clipNode.Xattrs))
+	size := 0
+	for name := range n.clipNode.Xattrs {
+		names = append(names, name)
+		size += len(name) + 1
+	}
+	sort.Strings(names)
+
+	if len(dest) < size {
+		return uint32(size), syscall.ERANGE
+	}
+
+	pos := 0
+	for _, name := range names {
+		pos += copy(dest[pos:], name)
+		dest[pos] = 0
+		pos++
+	}
+
+	return uint32(pos), fs.OK
+}
+
 func (n *FSNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 	n.log("Readdir called")
 
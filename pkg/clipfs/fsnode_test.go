@@ -0,0 +1,216 @@
+package clipfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/beam-cloud/clip/pkg/common"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// fixedContentCache returns the same content for every read and stores nothing
+type fixedContentCache struct {
+	content []byte
+}
+
+func (c *fixedContentCache) GetContent(hash string, offset int64, length int64) ([]byte, error) {
+	return c.content, nil
+}
+
+func (c *fixedContentCache) StoreContent(chunks chan []byte) (string, error) {
+	for range chunks {
+	}
+	return "", nil
+}
+
+func newXattrNode(enableXattrs bool, xattrs map[string][]byte) *FSNode {
+	cfs := &ClipFileSystem{enableXattrs: enableXattrs}
+	return &FSNode{filesystem: cfs, clipNode: &common.ClipNode{Path: "/file", NodeType: common.FileNode, Xattrs: xattrs}}
+}
+
+var testXattrs = map[string][]byte{
+	"user.test":           []byte("value"),
+	"security.capability": {0x01, 0x00, 0x00, 0x02},
+	"user.empty":          {},
+}
+
+func TestGetxattr(t *testing.T) {
+	ctx := context.Background()
+	n := newXattrNode(true, testXattrs)
+
+	tests := []struct {
+		name  string
+		attr  string
+		size  int
+		want  string
+		n     uint32
+		errno syscall.Errno
+	}{
+		{name: "value", attr: "user.test", size: 64, want: "value", n: 5},
+		{name: "exact size", attr: "user.test", size: 5, want: "value", n: 5},
+		{name: "size probe", attr: "user.test", size: 0, n: 5, errno: syscall.ERANGE},
+		{name: "buffer too small", attr: "user.test", size: 4, n: 5, errno: syscall.ERANGE},
+		{name: "binary value", attr: "security.capability", size: 4, want: "\x01\x00\x00\x02", n: 4},
+		{name: "empty value", attr: "user.empty", size: 0, n: 0},
+		{name: "missing", attr: "user.missing", size: 64, errno: syscall.ENODATA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := make([]byte, tt.size)
+			n, errno := n.Getxattr(ctx, tt.attr, dest)
+			if errno != tt.errno || n != tt.n {
+				t.Fatalf("expected (%d, %v), got (%d, %v)", tt.n, tt.errno, n, errno)
+			}
+			if errno == 0 && string(dest[:n]) != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, dest[:n])
+			}
+		})
+	}
+}
+
+func TestGetxattrDisabled(t *testing.T) {
+	n := newXattrNode(false, testXattrs)
+	if _, errno := n.Getxattr(context.Background(), "user.test", make([]byte, 64)); errno != syscall.ENOTSUP {
+		t.Fatalf("expected ENOTSUP, got %v", errno)
+	}
+}
+
+func TestListxattr(t *testing.T) {
+	ctx := context.Background()
+	n := newXattrNode(true, testXattrs)
+
+	// Names are sorted and each is NUL terminated
+	want := "security.capability\x00user.empty\x00user.test\x00"
+
+	dest := make([]byte, 128)
+	size, errno := n.Listxattr(ctx, dest)
+	if errno != 0 {
+		t.Fatalf("listxattr failed: %v", errno)
+	}
+	if got := string(dest[:size]); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	// Probing for the size and passing a buffer that's too small both return the size
+	for _, probe := range []int{0, len(want) - 1} {
+		size, errno := n.Listxattr(ctx, make([]byte, probe))
+		if errno != syscall.ERANGE || size != uint32(len(want)) {
+			t.Errorf("buffer of %d bytes: expected (%d, ERANGE), got (%d, %v)", probe, len(want), size, errno)
+		}
+	}
+
+	size, errno = n.Listxattr(ctx, make([]byte, len(want)))
+	if errno != 0 || size != uint32(len(want)) {
+		t.Errorf("exact size buffer: expected (%d, OK), got (%d, %v)", len(want), size, errno)
+	}
+}
+
+func TestListxattrEmpty(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		n := newXattrNode(enabled, nil)
+		if size, errno := n.Listxattr(context.Background(), nil); size != 0 || errno != 0 {
+			t.Errorf("enabled=%v: expected an empty list, got (%d, %v)", enabled, size, errno)
+		}
+	}
+
+	// Xattrs are not listed when disabled
+	n := newXattrNode(false, testXattrs)
+	if size, errno := n.Listxattr(context.Background(), make([]byte, 128)); size != 0 || errno != 0 {
+		t.Errorf("disabled: expected an empty list, got (%d, %v)", size, errno)
+	}
+}
+
+func TestReadVerifiesCachedContent(t *testing.T) {
+	data := []byte("hello world")
+
+	tests := []struct {
+		name   string
+		cached []byte
+		verify bool
+		off    int64
+		length int
+		want   string
+	}{
+		{name: "matching content", cached: data, verify: true, length: len(data), want: "hello world"},
+		{name: "too short", cached: data[:5], verify: true, length: len(data), want: "hello world"},
+		{name: "too long", cached: []byte("hello world!"), verify: true, length: len(data), want: "hello world"},
+		{name: "wrong hash", cached: []byte("HELLO WORLD"), verify: true, length: len(data), want: "hello world"},
+		{name: "partial read wrong length", cached: []byte("wor"), verify: true, off: 6, length: 5, want: "world"},
+		{name: "verification disabled", cached: []byte("HELLO WORLD"), length: len(data), want: "HELLO WORLD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfs, err := NewFileSystem(newMemStorage(map[string][]byte{"/file": data}), ClipFileSystemOpts{
+				ContentCache:          &fixedContentCache{content: tt.cached},
+				ContentCacheAvailable: true,
+				VerifyContentCache:    tt.verify,
+			})
+			if err != nil {
+				t.Fatalf("unable to create filesystem: %v", err)
+			}
+
+			result, errno := testNode(cfs, "/file").Read(context.Background(), nil, make([]byte, tt.length), tt.off)
+			if errno != 0 {
+				t.Fatalf("read failed: %v", errno)
+			}
+			got, _ := result.Bytes(nil)
+			if string(got) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+
+			if err := FlushContentCacheStores(context.Background()); err != nil {
+				t.Fatalf("flush failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadErrno(t *testing.T) {
+	tests := []struct {
+		err  error
+		want syscall.Errno
+	}{
+		{err: common.ErrStorageNotFound, want: syscall.ENOENT},
+		{err: fmt.Errorf("%w: missing", common.ErrStorageNotFound), want: syscall.ENOENT},
+		{err: os.ErrNotExist, want: syscall.ENOENT},
+		{err: common.ErrStorageAccessDenied, want: syscall.EACCES},
+		{err: os.ErrPermission, want: syscall.EACCES},
+		{err: common.ErrStorageTimeout, want: syscall.ETIMEDOUT},
+		{err: fmt.Errorf("read: %w", context.DeadlineExceeded), want: syscall.ETIMEDOUT},
+		{err: errors.New("connection reset"), want: syscall.EIO},
+	}
+
+	for _, tt := range tests {
+		if got := readErrno(tt.err); got != tt.want {
+			t.Errorf("%v: expected %v, got %v", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestOpenKeepCache(t *testing.T) {
+	for _, immutable := range []bool{true, false} {
+		cfs, err := NewFileSystem(newMemStorage(map[string][]byte{"/file": []byte("hello")}), ClipFileSystemOpts{Immutable: immutable})
+		if err != nil {
+			t.Fatalf("unable to create filesystem: %v", err)
+		}
+
+		var want uint32
+		if immutable {
+			want = fuse.FOPEN_KEEP_CACHE
+		}
+
+		_, flags, errno := testNode(cfs, "/file").Open(context.Background(), syscall.O_RDONLY)
+		if errno != 0 {
+			t.Fatalf("open failed: %v", errno)
+		}
+		if flags != want {
+			t.Errorf("immutable %v: expected flags %#x, got %#x", immutable, want, flags)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package clipfs
+
+const raceEnabled = false
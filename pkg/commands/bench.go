@@ -0,0 +1,44 @@
+package commands
+
+import (
+	log "github.com/okteto/okteto/pkg/log"
+
+	"github.com/beam-cloud/clip/pkg/clip"
+	"github.com/spf13/cobra"
+)
+
+var benchOpts = &clip.BenchOptions{}
+
+var BenchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure read performance of an archive",
+	RunE:  runBench,
+}
+
+func init() {
+	BenchCmd.Flags().StringVarP(&benchOpts.ArchivePath, "input", "i", "", "Archive file to benchmark")
+	BenchCmd.Flags().StringVarP(&benchOpts.CachePath, "cache", "c", "", "Cache clip locally")
+	BenchCmd.Flags().IntVarP(&benchOpts.Files, "files", "n", 100, "Number of files to read (0 reads every file)")
+	BenchCmd.Flags().StringVarP(&benchOpts.Pattern, "pattern", "p", clip.BenchPatternSequential, "Read pattern: sequential or random")
+	BenchCmd.Flags().IntVar(&benchOpts.ReadSize, "read-size", 1<<17, "Size of each read in bytes")
+	BenchCmd.MarkFlagRequired("input")
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	result, err := clip.BenchArchive(*benchOpts)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Files read:      %d\n", result.Files)
+	log.Printf("Reads:           %d\n", result.Reads)
+	log.Printf("Bytes read:      %d\n", result.Bytes)
+	log.Printf("Duration:        %v\n", result.Duration)
+	log.Printf("Throughput:      %.2f MB/s\n", result.Throughput()/(1<<20))
+	log.Printf("Latency p50:     %v\n", result.P50)
+	log.Printf("Latency p99:     %v\n", result.P99)
+	log.Printf("Cache hits:      %d\n", result.CacheHits)
+	log.Printf("Cache misses:    %d\n", result.CacheMisses)
+	log.Printf("Cached locally:  %v\n", result.CachedLocally)
+	return nil
+}
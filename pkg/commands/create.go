@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"github.com/beam-cloud/clip/pkg/archive"
 	"github.com/beam-cloud/clip/pkg/clip"
 	"github.com/spf13/cobra"
 )
@@ -17,6 +18,8 @@ func init() {
 	CreateCmd.Flags().StringVarP(&createOpts.InputPath, "input", "i", "", "Input directory to archive")
 	CreateCmd.Flags().StringVarP(&createOpts.OutputPath, "output", "o", "test.clip", "Output file for the archive")
 	CreateCmd.Flags().BoolVarP(&createOpts.Verbose, "verbose", "v", false, "Verbose output")
+	CreateCmd.Flags().StringSliceVarP(&createOpts.PriorityPaths, "priority-path", "p", archive.DefaultPriorityPaths, "Write files under this path (relative to the input) first for better read locality, can be repeated. Pass \"\" to disable reordering")
+	CreateCmd.Flags().BoolVar(&createOpts.NodeTable, "node-table", false, "Also write a node table for single file lookups, roughly doubling the metadata size")
 	CreateCmd.MarkFlagRequired("input")
 }
 
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/beam-cloud/clip/pkg/clip"
+	"github.com/spf13/cobra"
+)
+
+var diffNameOnly bool
+
+var DiffCmd = &cobra.Command{
+	Use:   "diff <archive-a> <archive-b>",
+	Short: "Show the paths that differ between two archives",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runDiff,
+}
+
+func init() {
+	DiffCmd.Flags().BoolVar(&diffNameOnly, "name-only", false, "Only print the paths that changed")
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	result, err := clip.DiffArchives(clip.DiffOptions{ArchivePathA: args[0], ArchivePathB: args[1]})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range result.Entries {
+		if diffNameOnly {
+			fmt.Println(entry.Path)
+			continue
+		}
+
+		switch entry.Change {
+		case clip.DiffAdded:
+			fmt.Printf("A %s\n", entry.Path)
+		case clip.DiffRemoved:
+			fmt.Printf("D %s\n", entry.Path)
+		case clip.DiffModified:
+			fmt.Printf("M %s (%s)\n", entry.Path, strings.Join(entry.Details, ", "))
+		}
+	}
+
+	if !diffNameOnly {
+		fmt.Printf("%d added, %d removed, %d modified\n", result.Added, result.Removed, result.Modified)
+	}
+
+	return nil
+}
@@ -15,11 +15,17 @@ var ExtractCmd = &cobra.Command{
 
 func init() {
 	ExtractCmd.Flags().StringVarP(&extractOpts.InputFile, "input", "i", "", "Input file to extract")
-	ExtractCmd.Flags().StringVarP(&extractOpts.OutputPath, "output", "o", ".", "Output path for the extraction")
+	ExtractCmd.Flags().StringVarP(&extractOpts.OutputPath, "output", "o", ".", "Output path for the extraction, defaults to stdout with --tar")
 	ExtractCmd.Flags().BoolVarP(&extractOpts.Verbose, "verbose", "v", false, "Verbose output")
+	ExtractCmd.Flags().BoolVarP(&extractOpts.Tar, "tar", "t", false, "Write a tar stream to the output path (- for stdout)")
 	ExtractCmd.MarkFlagRequired("input")
 }
 
 func runExtract(cmd *cobra.Command, args []string) error {
+	// A tar stream can't be written to the default output directory
+	if extractOpts.Tar && !cmd.Flags().Changed("output") {
+		extractOpts.OutputPath = "-"
+	}
+
 	return clip.ExtractArchive(*extractOpts)
 }
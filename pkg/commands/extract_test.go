@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/clip"
+	"golang.org/x/sys/unix"
+)
+
+func TestExtractTarToStdout(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "src")
+
+	files := map[string]string{
+		"a.txt":           "hello",
+		"dir/b.txt":       "world",
+		"dir/nested/c.sh": "#!/bin/sh\n",
+		"empty":           "",
+	}
+	for path, content := range files {
+		path = filepath.Join(sourcePath, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(sourcePath, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give entries distinct modes, and whole second mtimes so they survive the tar header
+	for path, mode := range map[string]os.FileMode{"a.txt": 0600, "dir/nested/c.sh": 0755, "dir/nested": 0750} {
+		if err := os.Chmod(filepath.Join(sourcePath, path), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Unix(1600000000, 0)
+	err := filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ts := []unix.Timeval{unix.NsecToTimeval(mtime.UnixNano()), unix.NsecToTimeval(mtime.UnixNano())}
+		return unix.Lutimes(path, ts)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Extended attributes are only checked where the filesystem supports them
+	xattrs := map[string]map[string]string{}
+	if err := unix.Lsetxattr(filepath.Join(sourcePath, "dir/b.txt"), "user.test", []byte("value"), 0); err == nil {
+		xattrs["dir/b.txt"] = map[string]string{"user.test": "value"}
+	} else if !errors.Is(err, unix.ENOTSUP) && !errors.Is(err, unix.EPERM) {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(dir, "test.clip")
+	if err := clip.CreateArchive(clip.CreateOptions{InputPath: sourcePath, OutputPath: archivePath}); err != nil {
+		t.Fatalf("unable to create archive: %v", err)
+	}
+
+	// Without -o, --tar writes to stdout
+	tarPath := filepath.Join(dir, "test.tar")
+	stdout, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	realStdout := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = realStdout }()
+
+	ExtractCmd.SetArgs([]string{"-i", archivePath, "--tar"})
+	err = ExtractCmd.Execute()
+	os.Stdout = realStdout
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+
+	if _, err := stdout.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	tr := tar.NewReader(stdout)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error reading tar stream: %v", err)
+		}
+		seen[hdr.Name] = true
+
+		// Attributes must match the source tree
+		var stat syscall.Stat_t
+		if err := syscall.Lstat(filepath.Join(sourcePath, strings.TrimSuffix(hdr.Name, "/")), &stat); err != nil {
+			t.Errorf("%s: not in the source tree: %v", hdr.Name, err)
+			continue
+		}
+		if want := int64(stat.Mode & 0777); hdr.Mode != want {
+			t.Errorf("%s: expected mode %o, got %o", hdr.Name, want, hdr.Mode)
+		}
+		if hdr.Uid != int(stat.Uid) || hdr.Gid != int(stat.Gid) {
+			t.Errorf("%s: expected owner %d:%d, got %d:%d", hdr.Name, stat.Uid, stat.Gid, hdr.Uid, hdr.Gid)
+		}
+		if !hdr.ModTime.Equal(mtime) {
+			t.Errorf("%s: expected mtime %v, got %v", hdr.Name, mtime, hdr.ModTime)
+		}
+		for name, value := range xattrs[hdr.Name] {
+			if got := hdr.PAXRecords["SCHILY.xattr."+name]; got != value {
+				t.Errorf("%s: expected xattr %s to be %q, got %q", hdr.Name, name, value, got)
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			want, ok := files[hdr.Name]
+			if !ok {
+				t.Errorf("unexpected file %s", hdr.Name)
+				continue
+			}
+			got, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("%s: expected %q, got %q", hdr.Name, want, got)
+			}
+		case tar.TypeSymlink:
+			if hdr.Name != "link" || hdr.Linkname != "a.txt" {
+				t.Errorf("unexpected symlink %s -> %s", hdr.Name, hdr.Linkname)
+			}
+		}
+	}
+
+	for path := range files {
+		if !seen[path] {
+			t.Errorf("%s missing from tar stream", path)
+		}
+	}
+	for _, path := range []string{"dir/", "dir/nested/", "link"} {
+		if !seen[path] {
+			t.Errorf("%s missing from tar stream", path)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/beam-cloud/clip/pkg/clip"
+	"github.com/spf13/cobra"
+)
+
+var inspectOpts = &clip.InspectOptions{}
+var inspectFormat string
+
+var InspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Print the metadata of an archive",
+	RunE:  runInspect,
+}
+
+func init() {
+	InspectCmd.Flags().StringVarP(&inspectOpts.ArchivePath, "input", "i", "", "Archive file to inspect")
+	InspectCmd.Flags().StringVarP(&inspectFormat, "format", "f", "text", "Output format: text or json")
+	InspectCmd.MarkFlagRequired("input")
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	info, err := clip.InspectArchive(*inspectOpts)
+	if err != nil {
+		return err
+	}
+
+	switch inspectFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	case "text":
+		fmt.Printf("Format version:   %d\n", info.FormatVersion)
+		fmt.Printf("Index:            %d bytes at offset %d\n", info.IndexLength, info.IndexPos)
+		if info.StorageInfoLength > 0 {
+			fmt.Printf("Storage info:     %d bytes at offset %d\n", info.StorageInfoLength, info.StorageInfoPos)
+		}
+		if info.NodeTableLength > 0 {
+			fmt.Printf("Node table:       %d bytes at offset %d\n", info.NodeTableLength, info.NodeTablePos)
+		}
+		if info.MetadataChecksum != 0 {
+			fmt.Printf("Checksum:         %016x\n", info.MetadataChecksum)
+		}
+		fmt.Printf("Storage type:     %s\n", info.StorageType)
+		if info.StorageInfo != nil {
+			fmt.Printf("Storage details:  %+v\n", info.StorageInfo)
+		}
+		fmt.Printf("Nodes:            %d (%d files, %d directories, %d symlinks)\n", info.Nodes, info.Files, info.Directories, info.Symlinks)
+		fmt.Printf("Logical size:     %d bytes\n", info.DataSize)
+		fmt.Printf("Stored size:      %d bytes (after deduplication)\n", info.StoredDataSize)
+		return nil
+	default:
+		return fmt.Errorf("unsupported format: %s", inspectFormat)
+	}
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/clip"
+	"github.com/beam-cloud/clip/pkg/common"
+	"github.com/spf13/cobra"
+)
+
+var lsOpts = &clip.ListOptions{}
+var lsLong bool
+
+var LsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the files in an archive",
+	RunE:  runLs,
+}
+
+func init() {
+	LsCmd.Flags().StringVarP(&lsOpts.ArchivePath, "input", "i", "", "Archive file to list")
+	LsCmd.Flags().StringVarP(&lsOpts.Path, "path", "p", "", "Only list the children of this directory")
+	LsCmd.Flags().BoolVarP(&lsLong, "long", "l", false, "Long format, including owner, modification time and content hash")
+	LsCmd.MarkFlagRequired("input")
+}
+
+func runLs(cmd *cobra.Command, args []string) error {
+	nodes, err := clip.ListArchive(*lsOpts)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	for _, node := range nodes {
+		name := node.Path
+		if node.IsSymlink() {
+			name = fmt.Sprintf("%s -> %s", node.Path, node.Target)
+		}
+
+		if lsLong {
+			contentHash := node.ContentHash
+			if contentHash == "" {
+				contentHash = "-"
+			}
+
+			mtime := time.Unix(int64(node.Attr.Mtime), int64(node.Attr.Mtimensec)).UTC().Format(time.RFC3339)
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n", node.NodeType, nodeMode(node), node.Attr.Owner.Uid, node.Attr.Owner.Gid, node.Attr.Size, mtime, contentHash, name)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", node.NodeType, nodeMode(node), node.Attr.Size, name)
+		}
+	}
+
+	return nil
+}
+
+// nodeMode formats the type and permission bits of a node the way ls -l does, including
+// the setuid, setgid and sticky bits
+func nodeMode(node *common.ClipNode) string {
+	mode := node.Attr.Mode
+
+	buf := []byte("----------")
+	switch node.NodeType {
+	case common.DirNode:
+		buf[0] = 'd'
+	case common.SymLinkNode:
+		buf[0] = 'l'
+	}
+
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			buf[i+1] = rwx[i]
+		}
+	}
+
+	// Special bits replace the execute bit they belong to, upper case if it isn't set
+	special := []struct {
+		bit   uint32
+		pos   int
+		char  byte
+		upper byte
+	}{
+		{syscall.S_ISUID, 3, 's', 'S'},
+		{syscall.S_ISGID, 6, 's', 'S'},
+		{syscall.S_ISVTX, 9, 't', 'T'},
+	}
+	for _, sp := range special {
+		if mode&sp.bit == 0 {
+			continue
+		}
+		if buf[sp.pos] == 'x' {
+			buf[sp.pos] = sp.char
+		} else {
+			buf[sp.pos] = sp.upper
+		}
+	}
+
+	return string(buf)
+}
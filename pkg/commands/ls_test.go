@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/beam-cloud/clip/pkg/common"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestNodeMode(t *testing.T) {
+	tests := []struct {
+		nodeType common.ClipNodeType
+		mode     uint32
+		want     string
+	}{
+		{common.FileNode, fuse.S_IFREG | 0644, "-rw-r--r--"},
+		{common.FileNode, fuse.S_IFREG | 0755, "-rwxr-xr-x"},
+		{common.DirNode, fuse.S_IFDIR | 0755, "drwxr-xr-x"},
+		{common.SymLinkNode, fuse.S_IFLNK | 0777, "lrwxrwxrwx"},
+		{common.FileNode, fuse.S_IFREG | 04755, "-rwsr-xr-x"},
+		{common.FileNode, fuse.S_IFREG | 04644, "-rwSr--r--"},
+		{common.FileNode, fuse.S_IFREG | 02755, "-rwxr-sr-x"},
+		{common.FileNode, fuse.S_IFREG | 02644, "-rw-r-Sr--"},
+		{common.DirNode, fuse.S_IFDIR | 01777, "drwxrwxrwt"},
+		{common.DirNode, fuse.S_IFDIR | 01770, "drwxrwx--T"},
+		{common.FileNode, fuse.S_IFREG | 07000, "---S--S--T"},
+		{common.FileNode, fuse.S_IFREG, "----------"},
+	}
+
+	for _, tt := range tests {
+		node := &common.ClipNode{NodeType: tt.nodeType, Attr: fuse.Attr{Mode: tt.mode}}
+		if got := nodeMode(node); got != tt.want {
+			t.Errorf("nodeMode(%s, %o) = %s, want %s", tt.nodeType, tt.mode, got, tt.want)
+		}
+	}
+}
@@ -23,6 +23,15 @@ func init() {
 	MountCmd.Flags().StringVarP(&mountOptions.MountPoint, "mountpoint", "m", "", "Directory to mount the archive")
 	MountCmd.Flags().BoolVarP(&mountOptions.Verbose, "verbose", "v", false, "Verbose output")
 	MountCmd.Flags().StringVarP(&mountOptions.CachePath, "cache", "c", "", "Cache clip locally")
+	MountCmd.Flags().BoolVar(&mountOptions.UseMmapCache, "mmap-cache", false, "Serve reads from a memory mapping of the local cache")
+	MountCmd.Flags().BoolVar(&mountOptions.Immutable, "immutable", false, "Keep page and attribute caches since the archive never changes")
+	MountCmd.Flags().BoolVar(&mountOptions.EnableXattrs, "xattrs", false, "Enable extended attributes on the mount")
+	MountCmd.Flags().DurationVar(&mountOptions.ReadTimeout, "read-timeout", 0, "Timeout for a single read from remote storage. 0 means no timeout for S3 and 60s for HTTP and service storage")
+	MountCmd.Flags().DurationVar(&mountOptions.AttrTimeout, "attr-timeout", 0, "Kernel attribute cache timeout (default 60s)")
+	MountCmd.Flags().DurationVar(&mountOptions.EntryTimeout, "entry-timeout", 0, "Kernel directory entry cache timeout (default 60s)")
+	MountCmd.Flags().IntVar(&mountOptions.MaxReadAhead, "max-readahead", 0, "Maximum kernel readahead in bytes (default 128Kb)")
+	MountCmd.Flags().IntVar(&mountOptions.MaxBackground, "max-background", 0, "Maximum number of background requests (default 512)")
+	MountCmd.Flags().BoolVar(&mountOptions.DisableSymlinkCaching, "disable-symlink-caching", false, "Disable kernel caching of symlink targets")
 	MountCmd.MarkFlagRequired("input")
 	MountCmd.MarkFlagRequired("mountpoint")
 }
@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"github.com/beam-cloud/clip/pkg/clip"
+	"github.com/spf13/cobra"
+)
+
+var serveOpts = &clip.ServeOptions{}
+
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve an archive to remote clients over HTTP",
+	RunE:  runServe,
+}
+
+func init() {
+	ServeCmd.Flags().StringVarP(&serveOpts.ArchivePath, "input", "i", "", "Archive file to serve")
+	ServeCmd.Flags().StringVarP(&serveOpts.Address, "address", "a", ":8080", "Address to listen on")
+	ServeCmd.Flags().StringVarP(&serveOpts.CachePath, "cache", "c", "", "Local path to cache a remote archive (optional)")
+	ServeCmd.Flags().StringVar(&serveOpts.BearerToken, "token", "", "Bearer token clients must present (optional)")
+	ServeCmd.MarkFlagRequired("input")
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	return clip.ServeArchive(*serveOpts)
+}
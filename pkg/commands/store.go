@@ -16,7 +16,14 @@ var StoreS3Cmd = &cobra.Command{
 	RunE:  runStoreS3,
 }
 
+var StoreHTTPCmd = &cobra.Command{
+	Use:   "http",
+	Short: "Generate an RCLIP archive backed by a CLIP archive served over http.",
+	RunE:  runStoreHTTP,
+}
+
 var storeS3Opts = &clip.StoreS3Options{}
+var storeHTTPOpts = &clip.StoreHTTPOptions{}
 
 func init() {
 	StoreCmd.AddCommand(StoreS3Cmd)
@@ -25,12 +32,30 @@ func init() {
 	StoreS3Cmd.Flags().StringVarP(&storeS3Opts.OutputFile, "output", "o", "", "Output RCLIP archive path")
 	StoreS3Cmd.Flags().StringVarP(&storeS3Opts.Bucket, "bucket", "b", "", "S3 bucket name")
 	StoreS3Cmd.Flags().StringVarP(&storeS3Opts.Key, "key", "k", "", "S3 bucket key (optional)")
+	StoreS3Cmd.Flags().StringVar(&storeS3Opts.SSEMode, "sse", "", "Server-side encryption mode: AES256 or aws:kms (optional)")
+	StoreS3Cmd.Flags().StringVar(&storeS3Opts.KMSKeyID, "kms-key-id", "", "KMS key id used with --sse aws:kms (optional)")
+	StoreS3Cmd.Flags().BoolVar(&storeS3Opts.UseDefaultCredentials, "use-default-credentials", false, "Use the AWS default credential chain (instance profile, IRSA) instead of static keys")
+	StoreS3Cmd.Flags().StringVar(&storeS3Opts.StorageClass, "storage-class", "", "S3 storage class, e.g. INTELLIGENT_TIERING (optional)")
 
 	StoreS3Cmd.MarkFlagRequired("input")
 	StoreS3Cmd.MarkFlagRequired("output")
 	StoreS3Cmd.MarkFlagRequired("bucket")
+
+	StoreCmd.AddCommand(StoreHTTPCmd)
+
+	StoreHTTPCmd.Flags().StringVarP(&storeHTTPOpts.ArchivePath, "input", "i", "", "Input CLIP archive path")
+	StoreHTTPCmd.Flags().StringVarP(&storeHTTPOpts.OutputFile, "output", "o", "", "Output RCLIP archive path")
+	StoreHTTPCmd.Flags().StringVarP(&storeHTTPOpts.URL, "url", "u", "", "URL the CLIP archive is served from")
+
+	StoreHTTPCmd.MarkFlagRequired("input")
+	StoreHTTPCmd.MarkFlagRequired("output")
+	StoreHTTPCmd.MarkFlagRequired("url")
 }
 
 func runStoreS3(cmd *cobra.Command, args []string) error {
 	return clip.StoreS3(*storeS3Opts)
 }
+
+func runStoreHTTP(cmd *cobra.Command, args []string) error {
+	return clip.StoreHTTP(*storeHTTPOpts)
+}
@@ -6,4 +6,10 @@ var (
 	ErrFileHeaderMismatch = errors.New("unexpected file header")
 	ErrCrcMismatch        = errors.New("crc64 mismatch")
 	ErrMissingArchiveRoot = errors.New("no root node found")
+	ErrMetadataCorrupt    = errors.New("archive metadata corrupt")
+
+	// Storage read failures, used to return accurate errnos from the filesystem
+	ErrStorageNotFound     = errors.New("archive not found in storage")
+	ErrStorageAccessDenied = errors.New("access to storage denied")
+	ErrStorageTimeout      = errors.New("storage read timed out")
 )
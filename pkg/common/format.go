@@ -3,13 +3,20 @@ package common
 import (
 	"bytes"
 	"encoding/gob"
+	"fmt"
 )
 
 var ClipFileStartBytes []byte = []byte{0x89, 0x43, 0x4C, 0x49, 0x50, 0x0D, 0x0A, 0x1A, 0x0A}
 
 const (
-	ClipHeaderLength            = 54
-	ClipFileFormatVersion uint8 = 0x01
+	// Archives are always written in the current version. Readers handle version 1 archives,
+	// but readers that predate version 2 can't read archives written now, so upgrade readers first.
+	ClipHeaderLength            = 78
+	ClipFileFormatVersion uint8 = 0x02
+
+	// Version 1 archives have no metadata checksum
+	ClipHeaderLengthV1            = 54
+	ClipFileFormatVersionV1 uint8 = 0x01
 )
 
 type ClipArchiveHeader struct {
@@ -20,10 +27,58 @@ type ClipArchiveHeader struct {
 	StorageInfoLength     int64
 	StorageInfoPos        int64
 	StorageInfoType       [12]byte
+	MetadataChecksum      uint64 // crc64 over the header, index and storage info, always zero in version 1
+	NodeTableLength       int64  // Zero if there is no node table, the table carries its own checksums
+	NodeTablePos          int64
+}
+
+// ClipArchiveHeaderV1 is the header layout used by version 1 archives
+type ClipArchiveHeaderV1 struct {
+	StartBytes            [9]byte
+	ClipFileFormatVersion uint8
+	IndexLength           int64
+	IndexPos              int64
+	StorageInfoLength     int64
+	StorageInfoPos        int64
+	StorageInfoType       [12]byte
 }
 
 /*
 
+The optional node table allows a single node to be looked up without decoding the whole index:
+
+	Header   ClipNodeTableHeader
+	Entries  [Count]ClipNodeTableEntry  // Sorted by node path
+	Prelude  [PreludeLength]byte        // gob type definitions shared by every node
+	Nodes    []byte                     // gob encoded nodes, without type definitions
+
+A node is decoded by feeding the prelude followed by the node bytes to a gob decoder.
+
+The table is not covered by the metadata checksum, since verifying that would mean reading
+the whole table. Instead the header checksum covers the count and the prelude, and each entry
+holds a checksum of its node bytes, so every part of the table a lookup reads is verified.
+
+*/
+
+type ClipNodeTableHeader struct {
+	Count         int64
+	PreludeLength int64
+	Checksum      uint64 // crc64 over Count, PreludeLength and the prelude
+}
+
+type ClipNodeTableEntry struct {
+	NodePos  int64
+	NodeLen  int64
+	Checksum uint64 // crc64 over the node bytes
+}
+
+const (
+	ClipNodeTableHeaderLength = 24
+	ClipNodeTableEntryLength  = 24
+)
+
+/*
+
 Data files are stored inside a clip in this format:
 
 	BlockType BlockType
@@ -49,12 +104,41 @@ type ClipStorageInfo interface {
 	Encode() ([]byte, error)
 }
 
+// DecodeStorageInfo decodes the storage info held in a StorageInfoWrapper
+func DecodeStorageInfo(wrapper StorageInfoWrapper) (ClipStorageInfo, error) {
+	switch wrapper.Type {
+	case "s3":
+		var s3Info S3StorageInfo
+		if err := gob.NewDecoder(bytes.NewReader(wrapper.Data)).Decode(&s3Info); err != nil {
+			return nil, fmt.Errorf("error decoding s3 storage info: %v", err)
+		}
+		return s3Info, nil
+	case "http":
+		var httpInfo HTTPStorageInfo
+		if err := gob.NewDecoder(bytes.NewReader(wrapper.Data)).Decode(&httpInfo); err != nil {
+			return nil, fmt.Errorf("error decoding http storage info: %v", err)
+		}
+		return httpInfo, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage info type: %s", wrapper.Type)
+	}
+}
+
 // Storage Info Implementations
 type S3StorageInfo struct {
 	Bucket   string
 	Region   string
 	Key      string
 	Endpoint string
+
+	// Applied when uploading the archive, e.g. "aws:kms" with a key id, or "INTELLIGENT_TIERING"
+	SSEMode      string
+	KMSKeyID     string
+	StorageClass string
+
+	// Ignore configured access keys and use the SDK default credential chain (environment,
+	// shared config, instance profile, IRSA, etc.)
+	UseDefaultCredentials bool
 }
 
 func (ssi S3StorageInfo) Type() string {
@@ -70,3 +154,21 @@ func (ssi S3StorageInfo) Encode() ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+type HTTPStorageInfo struct {
+	URL string
+}
+
+func (hsi HTTPStorageInfo) Type() string {
+	return "http"
+}
+
+func (hsi HTTPStorageInfo) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(hsi); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -23,6 +23,7 @@ type ClipNode struct {
 	ContentHash string
 	DataPos     int64 // Position of the nodes data in the final binary
 	DataLen     int64 // Length of the nodes data
+	Xattrs      map[string][]byte
 }
 
 // IsDir returns true if the ClipNode represents a directory.
@@ -53,6 +54,20 @@ func (m *ClipArchiveMetadata) Get(path string) *ClipNode {
 	return item.(*ClipNode)
 }
 
+// AscendPrefix calls fn for every node whose path starts with prefix, in path order.
+// Iteration starts at the prefix pivot and stops as soon as a path no longer matches,
+// so the cost is proportional to the size of the matching range rather than the index.
+// Returning false from fn stops the iteration early.
+func (m *ClipArchiveMetadata) AscendPrefix(prefix string, fn func(node *ClipNode) bool) {
+	m.Index.Ascend(&ClipNode{Path: prefix}, func(a interface{}) bool {
+		node := a.(*ClipNode)
+		if !strings.HasPrefix(node.Path, prefix) {
+			return false
+		}
+		return fn(node)
+	})
+}
+
 func (m *ClipArchiveMetadata) ListDirectory(path string) []fuse.DirEntry {
 	var entries []fuse.DirEntry
 
@@ -61,38 +76,18 @@ func (m *ClipArchiveMetadata) ListDirectory(path string) []fuse.DirEntry {
 		path += "/"
 	}
 
-	// Append null character to the path -- if we don't do this we could miss some child nodes.
-	// It works because \x00 is lower lexographically than any other character
-	pivot := &ClipNode{Path: path + "\x00"}
 	pathLen := len(path)
-
-	m.Index.Ascend(pivot, func(a interface{}) bool {
-		node := a.(*ClipNode)
-		nodePath := node.Path
-
-		// Check if this node path starts with 'path' (meaning it is a child --> continue)
-		if len(nodePath) < pathLen || nodePath[:pathLen] != path {
+	m.AscendPrefix(path, func(node *ClipNode) bool {
+		// Skip any node that isn't an immediate child
+		relativePath := node.Path[pathLen:]
+		if relativePath == "" || strings.Contains(relativePath, "/") {
 			return true
 		}
 
-		// Check if there are any "/" left after removing the prefix
-		for i := pathLen; i < len(nodePath); i++ {
-			if nodePath[i] == '/' {
-				if i == pathLen || nodePath[i-1] != '/' {
-					// This node is not an immediate child, continue on
-					return true
-				}
-			}
-		}
-
-		// Node is an immediate child, so we append it to entries
-		relativePath := nodePath[pathLen:]
-		if relativePath != "" {
-			entries = append(entries, fuse.DirEntry{
-				Mode: node.Attr.Mode,
-				Name: relativePath,
-			})
-		}
+		entries = append(entries, fuse.DirEntry{
+			Mode: node.Attr.Mode,
+			Name: relativePath,
+		})
 
 		return true
 	})
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/btree"
+)
+
+func newTestMetadata(paths ...string) *ClipArchiveMetadata {
+	m := &ClipArchiveMetadata{Index: btree.New(func(a, b interface{}) bool {
+		return a.(*ClipNode).Path < b.(*ClipNode).Path
+	})}
+	for _, path := range paths {
+		m.Insert(&ClipNode{Path: path, NodeType: FileNode})
+	}
+	return m
+}
+
+// "/a-b" sorts before "/a/" and "/a0" after it, so both sit right at the edges of the "/a/" range
+var testPaths = []string{"/", "/a", "/a-b", "/a-b/x", "/a/", "/a/x", "/a/y/z", "/a0", "/b"}
+
+func ascendPrefixPaths(m *ClipArchiveMetadata, prefix string) []string {
+	var paths []string
+	m.AscendPrefix(prefix, func(node *ClipNode) bool {
+		paths = append(paths, node.Path)
+		return true
+	})
+	return paths
+}
+
+func TestAscendPrefix(t *testing.T) {
+	m := newTestMetadata(testPaths...)
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "/a/", want: []string{"/a/", "/a/x", "/a/y/z"}},
+		{prefix: "/a", want: []string{"/a", "/a-b", "/a-b/x", "/a/", "/a/x", "/a/y/z", "/a0"}},
+		{prefix: "/a-b/", want: []string{"/a-b/x"}},
+		{prefix: "/", want: testPaths},
+		{prefix: "", want: testPaths},
+		{prefix: "/a/y/z", want: []string{"/a/y/z"}},
+		{prefix: "/c", want: nil},
+		{prefix: "/a/w", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := ascendPrefixPaths(m, tt.prefix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AscendPrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestAscendPrefixStopsEarly(t *testing.T) {
+	m := newTestMetadata(testPaths...)
+
+	var paths []string
+	m.AscendPrefix("/a", func(node *ClipNode) bool {
+		paths = append(paths, node.Path)
+		return len(paths) < 2
+	})
+
+	if want := []string{"/a", "/a-b"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expected iteration to stop after %q, got %q", want, paths)
+	}
+}
+
+func TestListDirectory(t *testing.T) {
+	m := newTestMetadata("/", "/a", "/a-b", "/a-b/x", "/a/x", "/a/y", "/a/y/z", "/a0", "/b")
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "/", want: []string{"a", "a-b", "a0", "b"}},
+		{path: "/a", want: []string{"x", "y"}},
+		{path: "/a/", want: []string{"x", "y"}},
+		{path: "/a-b", want: []string{"x"}},
+		{path: "/b", want: nil},
+	}
+
+	for _, tt := range tests {
+		var names []string
+		for _, entry := range m.ListDirectory(tt.path) {
+			names = append(names, entry.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("ListDirectory(%q) = %q, want %q", tt.path, names, tt.want)
+		}
+	}
+}
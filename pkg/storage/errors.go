@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+// wrapRemoteError classifies an error from a remote read so callers can check it
+// against the common storage errors with errors.Is
+func wrapRemoteError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %v", common.ErrStorageTimeout, err)
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		if classified := errorFromStatusCode(statusErr.HTTPStatusCode()); classified != nil {
+			return fmt.Errorf("%w: %v", classified, err)
+		}
+	}
+
+	return err
+}
+
+func errorFromStatusCode(code int) error {
+	switch code {
+	case http.StatusNotFound:
+		return common.ErrStorageNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return common.ErrStorageAccessDenied
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return common.ErrStorageTimeout
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+// statusError is a remote error carrying an HTTP status code, like the AWS SDK response errors
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string       { return fmt.Sprintf("status %d", e.code) }
+func (e *statusError) HTTPStatusCode() int { return e.code }
+
+func TestWrapRemoteError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "not found", err: &statusError{http.StatusNotFound}, want: common.ErrStorageNotFound},
+		{name: "unauthorized", err: &statusError{http.StatusUnauthorized}, want: common.ErrStorageAccessDenied},
+		{name: "forbidden", err: fmt.Errorf("get object: %w", &statusError{http.StatusForbidden}), want: common.ErrStorageAccessDenied},
+		{name: "gateway timeout", err: &statusError{http.StatusGatewayTimeout}, want: common.ErrStorageTimeout},
+		{name: "context deadline", err: fmt.Errorf("read: %w", context.DeadlineExceeded), want: common.ErrStorageTimeout},
+		{name: "network timeout", err: &net.DNSError{Err: "timeout", IsTimeout: true}, want: common.ErrStorageTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := wrapRemoteError(tt.err); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// Unclassified errors are returned as they are
+	for _, err := range []error{errors.New("connection reset"), &statusError{http.StatusInternalServerError}} {
+		if got := wrapRemoteError(err); got != err {
+			t.Errorf("expected %v to be returned unchanged, got %v", err, got)
+		}
+	}
+	if err := wrapRemoteError(nil); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestErrorFromStatusCode(t *testing.T) {
+	tests := []struct {
+		code int
+		want error
+	}{
+		{code: http.StatusNotFound, want: common.ErrStorageNotFound},
+		{code: http.StatusUnauthorized, want: common.ErrStorageAccessDenied},
+		{code: http.StatusForbidden, want: common.ErrStorageAccessDenied},
+		{code: http.StatusRequestTimeout, want: common.ErrStorageTimeout},
+		{code: http.StatusGatewayTimeout, want: common.ErrStorageTimeout},
+		{code: http.StatusInternalServerError, want: nil},
+		{code: http.StatusBadRequest, want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := errorFromStatusCode(tt.code); got != tt.want {
+			t.Errorf("status %d: expected %v, got %v", tt.code, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+type HTTPClipStorageCredentials struct {
+	BearerToken string
+}
+
+type HTTPClipStorage struct {
+	url         string
+	bearerToken string
+	client      *http.Client
+	metadata    *common.ClipArchiveMetadata
+}
+
+type HTTPClipStorageOpts struct {
+	URL         string
+	BearerToken string
+	ReadTimeout time.Duration // Timeout for a single range request, defaults to 60s
+}
+
+const defaultHTTPReadTimeout = time.Second * 60
+
+func NewHTTPClipStorage(metadata *common.ClipArchiveMetadata, opts HTTPClipStorageOpts) (*HTTPClipStorage, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("no url provided for http storage")
+	}
+
+	readTimeout := opts.ReadTimeout
+	if readTimeout <= 0 {
+		readTimeout = defaultHTTPReadTimeout
+	}
+
+	return &HTTPClipStorage{
+		url:         opts.URL,
+		bearerToken: opts.BearerToken,
+		client:      &http.Client{Timeout: readTimeout},
+		metadata:    metadata,
+	}, nil
+}
+
+func (s *HTTPClipStorage) ReadFile(node *common.ClipNode, dest []byte, off int64) (int, error) {
+	dest = clampRead(node, dest, off)
+	if len(dest) == 0 {
+		return 0, nil
+	}
+
+	start := node.DataPos + off
+	end := start + int64(len(dest)) - 1
+
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if s.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearerToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, wrapRemoteError(err)
+	}
+	defer resp.Body.Close()
+
+	if classified := errorFromStatusCode(resp.StatusCode); classified != nil {
+		return 0, fmt.Errorf("%w: range request <%s>: %s", classified, s.url, resp.Status)
+	}
+
+	// A server that ignores the range header would send the whole archive
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("unexpected response for range request <%s>: %s", s.url, resp.Status)
+	}
+
+	// dest is clamped to the end of the file, so a short body means the response was cut off
+	n, err := io.ReadFull(resp.Body, dest)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return n, fmt.Errorf("truncated response for range request <%s>: got %d of %d bytes: %w", s.url, n, len(dest), io.ErrUnexpectedEOF)
+	}
+
+	return n, wrapRemoteError(err)
+}
+
+func (s *HTTPClipStorage) CachedLocally() bool {
+	return false
+}
+
+func (s *HTTPClipStorage) Metadata() *common.ClipArchiveMetadata {
+	return s.metadata
+}
+
+func (s *HTTPClipStorage) Cleanup() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+func newTestHTTPStorage(t *testing.T, handler http.Handler) *HTTPClipStorage {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	s, err := NewHTTPClipStorage(nil, HTTPClipStorageOpts{URL: server.URL, ReadTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to create http storage: %v", err)
+	}
+	t.Cleanup(func() { s.Cleanup() })
+
+	return s
+}
+
+// serveArchive answers range requests for archive like a static file server
+func serveArchive(archive []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "archive.clip", time.Time{}, bytes.NewReader(archive))
+	})
+}
+
+func TestHTTPReadFileRange(t *testing.T) {
+	archive := []byte("headerhello worldtrailer")
+	node := &common.ClipNode{Path: "/file", NodeType: common.FileNode, DataPos: 6, DataLen: 11}
+	s := newTestHTTPStorage(t, serveArchive(archive))
+
+	dest := make([]byte, 5)
+	n, err := s.ReadFile(node, dest, 6)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got := string(dest[:n]); got != "world" {
+		t.Fatalf("expected %q, got %q", "world", got)
+	}
+}
+
+func TestHTTPReadFileTruncatedResponse(t *testing.T) {
+	node := &common.ClipNode{Path: "/file", NodeType: common.FileNode, DataPos: 0, DataLen: 11}
+
+	// Promise the whole range but drop the connection part way through the body
+	s := newTestHTTPStorage(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-10/11")
+		w.Header().Set("Content-Length", strconv.Itoa(11))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("hello"))
+	}))
+
+	dest := make([]byte, 11)
+	n, err := s.ReadFile(node, dest, 0)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected unexpected EOF, got n=%d err=%v", n, err)
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 bytes before the truncation, got %d", n)
+	}
+}
+
+func TestHTTPReadFileRangeIgnored(t *testing.T) {
+	node := &common.ClipNode{Path: "/file", NodeType: common.FileNode, DataPos: 0, DataLen: 11}
+	s := newTestHTTPStorage(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello world"))
+	}))
+
+	if _, err := s.ReadFile(node, make([]byte, 11), 0); err == nil {
+		t.Fatalf("expected an error when the server ignores the range header")
+	}
+}
+
+func TestHTTPReadFileNotFound(t *testing.T) {
+	node := &common.ClipNode{Path: "/file", NodeType: common.FileNode, DataPos: 0, DataLen: 11}
+	s := newTestHTTPStorage(t, http.NotFoundHandler())
+
+	if _, err := s.ReadFile(node, make([]byte, 11), 0); !errors.Is(err, common.ErrStorageNotFound) {
+		t.Fatalf("expected ErrStorageNotFound, got %v", err)
+	}
+}
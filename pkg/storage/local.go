@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/beam-cloud/clip/pkg/common"
@@ -31,8 +32,14 @@ func NewLocalClipStorage(metadata *common.ClipArchiveMetadata, opts LocalClipSto
 }
 
 func (s *LocalClipStorage) ReadFile(node *common.ClipNode, dest []byte, off int64) (int, error) {
+	dest = clampRead(node, dest, off)
+	if len(dest) == 0 {
+		return 0, nil
+	}
+
+	// ReadAt may report io.EOF along with a full read when the file ends the archive
 	n, err := s.fileHandle.ReadAt(dest, node.DataPos+off)
-	if err != nil {
+	if err != nil && !(err == io.EOF && n == len(dest)) {
 		return n, fmt.Errorf("unable to read data from file: %w", err)
 	}
 	return n, nil
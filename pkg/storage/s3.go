@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -15,9 +16,11 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/beam-cloud/clip/pkg/common"
 	"github.com/gofrs/flock"
 	"github.com/google/uuid"
+	"golang.org/x/sys/unix"
 )
 
 type S3ClipStorageCredentials struct {
@@ -33,29 +36,66 @@ type S3ClipStorage struct {
 	secretKey      string
 	metadata       *common.ClipArchiveMetadata
 	localCachePath string
+	cacheFileMode  os.FileMode
+	forceFileMode  bool // Apply cacheFileMode to existing cache files too, set when a mode is configured
 	cachedLocally  bool
 	cacheFile      *os.File
+	useMmapCache   bool
+	cacheMmap      []byte
+	cacheMmapMu    sync.RWMutex // Held for reading across copies out of cacheMmap, so Cleanup can't unmap it mid-read
+	closed         bool         // Set by Cleanup, so a download finishing later doesn't map or open the cache again
+	readTimeout    time.Duration
+	sseMode        types.ServerSideEncryption
+	kmsKeyID       string
+	storageClass   types.StorageClass
 }
 
 type S3ClipStorageOpts struct {
-	Bucket    string
-	Key       string
-	Region    string
-	Endpoint  string
-	CachePath string
-	AccessKey string
-	SecretKey string
+	Bucket        string
+	Key           string
+	Region        string
+	Endpoint      string
+	CachePath     string
+	CacheFileMode os.FileMode // Permissions for a new local cache file, defaults to 0644. If set, existing files are changed too.
+	AccessKey     string
+	SecretKey     string
+	UseMmapCache  bool          // Serve reads from a memory mapping of the local cache file
+	ReadTimeout   time.Duration // Timeout for a single ranged read from S3, zero means no timeout. Not applied to metadata requests or the background download.
+	SSEMode       string        // Server-side encryption for uploads, "AES256" or "aws:kms"
+	KMSKeyID      string        // KMS key used when SSEMode is "aws:kms", the bucket default if empty
+	StorageClass  string        // Storage class for uploads, the bucket default if empty
+
+	// Ignore AccessKey/SecretKey and let the SDK resolve credentials from its default chain:
+	// the AWS_* environment variables, shared config files, then an instance profile or IRSA role
+	UseDefaultCredentials bool
 }
 
 const backgroundDownloadStartupDelay = time.Second * 30
+const defaultCacheFileMode os.FileMode = 0644
 
 func NewS3ClipStorage(metadata *common.ClipArchiveMetadata, opts S3ClipStorageOpts) (*S3ClipStorage, error) {
-	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
-	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	sseMode := types.ServerSideEncryption(opts.SSEMode)
+	if opts.SSEMode != "" && !isKnownValue(sseMode, sseMode.Values()) {
+		return nil, fmt.Errorf("unsupported server-side encryption mode: %s", opts.SSEMode)
+	}
+	if opts.KMSKeyID != "" && sseMode != types.ServerSideEncryptionAwsKms && sseMode != types.ServerSideEncryptionAwsKmsDsse {
+		return nil, fmt.Errorf("a KMS key id requires server-side encryption mode %s", types.ServerSideEncryptionAwsKms)
+	}
 
-	if opts.AccessKey != "" && opts.SecretKey != "" {
-		accessKey = opts.AccessKey
-		secretKey = opts.SecretKey
+	storageClass := types.StorageClass(opts.StorageClass)
+	if opts.StorageClass != "" && !isKnownValue(storageClass, storageClass.Values()) {
+		return nil, fmt.Errorf("unsupported storage class: %s", opts.StorageClass)
+	}
+
+	var accessKey, secretKey string
+	if !opts.UseDefaultCredentials {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+		if opts.AccessKey != "" && opts.SecretKey != "" {
+			accessKey = opts.AccessKey
+			secretKey = opts.SecretKey
+		}
 	}
 
 	cfg, err := getAWSConfig(accessKey, secretKey, opts.Region, opts.Endpoint)
@@ -74,6 +114,11 @@ func NewS3ClipStorage(metadata *common.ClipArchiveMetadata, opts S3ClipStorageOp
 		return nil, fmt.Errorf("cannot access bucket <%s>: %v", opts.Bucket, err)
 	}
 
+	cacheFileMode := opts.CacheFileMode
+	if cacheFileMode == 0 {
+		cacheFileMode = defaultCacheFileMode
+	}
+
 	c := &S3ClipStorage{
 		svc:            svc,
 		bucket:         opts.Bucket,
@@ -82,12 +127,19 @@ func NewS3ClipStorage(metadata *common.ClipArchiveMetadata, opts S3ClipStorageOp
 		secretKey:      secretKey,
 		metadata:       metadata,
 		localCachePath: opts.CachePath,
+		cacheFileMode:  cacheFileMode,
+		forceFileMode:  opts.CacheFileMode != 0,
 		cachedLocally:  false,
 		cacheFile:      nil,
+		useMmapCache:   opts.UseMmapCache,
+		readTimeout:    opts.ReadTimeout,
+		sseMode:        sseMode,
+		kmsKeyID:       opts.KMSKeyID,
+		storageClass:   storageClass,
 	}
 
 	if opts.CachePath != "" {
-		cacheFile, err := os.OpenFile(opts.CachePath, os.O_RDWR|os.O_CREATE, 0644)
+		cacheFile, err := c.openCacheFile(opts.CachePath, os.O_RDWR|os.O_CREATE)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open cache file <%s>: %v", opts.CachePath, err)
 		}
@@ -98,6 +150,39 @@ func NewS3ClipStorage(metadata *common.ClipArchiveMetadata, opts S3ClipStorageOp
 	return c, nil
 }
 
+func isKnownValue[T comparable](v T, known []T) bool {
+	for _, k := range known {
+		if v == k {
+			return true
+		}
+	}
+	return false
+}
+
+// openCacheFile opens a local cache file. Files it creates get the configured permissions
+// regardless of the process umask, existing files are only changed if a mode was configured.
+func (s3c *S3ClipStorage) openCacheFile(path string, flag int) (*os.File, error) {
+	// Try to create the file exclusively first, to tell whether this call created it
+	created := flag&os.O_CREATE != 0
+	f, err := os.OpenFile(path, flag|os.O_EXCL, s3c.cacheFileMode)
+	if created && os.IsExist(err) {
+		created = false
+		f, err = os.OpenFile(path, flag&^os.O_CREATE, s3c.cacheFileMode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if created || s3c.forceFileMode {
+		if err := f.Chmod(s3c.cacheFileMode); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
 func getAWSConfig(accessKey string, secretKey string, region string, endpoint string) (aws.Config, error) {
 	var cfg aws.Config
 	var err error
@@ -189,12 +274,19 @@ func (s3c *S3ClipStorage) Upload(ctx context.Context, archivePath string, progre
 		u.Concurrency = 128
 	})
 
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(s3c.bucket),
-		Key:           aws.String(s3c.key),
-		Body:          pr,
-		ContentLength: &length,
-	})
+	input := &s3.PutObjectInput{
+		Bucket:               aws.String(s3c.bucket),
+		Key:                  aws.String(s3c.key),
+		Body:                 pr,
+		ContentLength:        &length,
+		ServerSideEncryption: s3c.sseMode,
+		StorageClass:         s3c.storageClass,
+	}
+	if s3c.kmsKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s3c.kmsKeyID)
+	}
+
+	_, err = uploader.Upload(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to upload archive: %v", err)
 	}
@@ -213,7 +305,13 @@ func (s3c *S3ClipStorage) startBackgroundDownload() {
 	if err == nil {
 		if cacheFileInfo.Size() == totalSize {
 			log.Printf("Cache file <%s> exists.\n", s3c.localCachePath)
-			s3c.cachedLocally = true
+
+			s3c.cacheMmapMu.Lock()
+			defer s3c.cacheMmapMu.Unlock()
+			if !s3c.closed {
+				s3c.mapCacheFile(totalSize)
+				s3c.cachedLocally = true
+			}
 			return
 		}
 	}
@@ -221,6 +319,13 @@ func (s3c *S3ClipStorage) startBackgroundDownload() {
 	// Wait a bit before kicking off the background download job
 	time.Sleep(backgroundDownloadStartupDelay)
 
+	s3c.cacheMmapMu.RLock()
+	closed := s3c.closed
+	s3c.cacheMmapMu.RUnlock()
+	if closed {
+		return
+	}
+
 	tmpCacheFile := fmt.Sprintf("%s.%s", s3c.localCachePath, uuid.New().String()[:6])
 	lockFilePath := fmt.Sprintf("%s.lock", s3c.localCachePath)
 
@@ -246,7 +351,7 @@ func (s3c *S3ClipStorage) startBackgroundDownload() {
 	downloader := manager.NewDownloader(s3c.svc)
 	downloader.Concurrency = 32
 
-	f, err := os.Create(tmpCacheFile)
+	f, err := s3c.openCacheFile(tmpCacheFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
 		log.Printf("Failed to create file %q, %v", s3c.localCachePath, err)
 		return
@@ -269,21 +374,47 @@ func (s3c *S3ClipStorage) startBackgroundDownload() {
 		return
 	}
 
-	// Close open file handle after rename
-	s3c.cacheFile.Close()
-
 	// Re-open cached file
-	cacheFile, err := os.OpenFile(s3c.localCachePath, os.O_RDWR|os.O_CREATE, 0644)
+	cacheFile, err := s3c.openCacheFile(s3c.localCachePath, os.O_RDWR|os.O_CREATE)
 	if err != nil {
 		return
 	}
 
 	log.Printf("Archive <%v> cached in %v", s3c.localCachePath, time.Since(startTime))
 
+	s3c.cacheMmapMu.Lock()
+	defer s3c.cacheMmapMu.Unlock()
+
+	// Cleanup already ran, so nothing would close the new file or unmap it
+	if s3c.closed {
+		cacheFile.Close()
+		return
+	}
+
+	// Close open file handle after rename
+	s3c.cacheFile.Close()
+
 	s3c.cacheFile = cacheFile
+	s3c.mapCacheFile(totalSize)
 	s3c.cachedLocally = true
 }
 
+// mapCacheFile memory maps the local cache file if mmap reads are enabled. The caller
+// must hold cacheMmapMu. On failure, reads continue to go through the cache file handle.
+func (s3c *S3ClipStorage) mapCacheFile(size int64) {
+	if !s3c.useMmapCache || size <= 0 {
+		return
+	}
+
+	data, err := unix.Mmap(int(s3c.cacheFile.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		log.Printf("Unable to mmap cache file <%s>: %v", s3c.localCachePath, err)
+		return
+	}
+
+	s3c.cacheMmap = data
+}
+
 func (s3c *S3ClipStorage) CachedLocally() bool {
 	return s3c.cachedLocally
 }
@@ -314,6 +445,11 @@ func (s3c *S3ClipStorage) getContentFromSource(dest []byte, start, end int64) (i
 }
 
 func (s3c *S3ClipStorage) ReadFile(node *common.ClipNode, dest []byte, off int64) (int, error) {
+	dest = clampRead(node, dest, off)
+	if len(dest) == 0 {
+		return 0, nil
+	}
+
 	start := node.DataPos + off
 	end := start + int64(len(dest)) - 1
 
@@ -321,9 +457,14 @@ func (s3c *S3ClipStorage) ReadFile(node *common.ClipNode, dest []byte, off int64
 		return s3c.getContentFromSource(dest, start, end)
 	}
 
+	// Read from the memory mapped cache
+	if n, ok := s3c.readCacheMmap(dest, start); ok {
+		return n, nil
+	}
+
 	// Read from local cache
 	n, err := s3c.cacheFile.ReadAt(dest, start)
-	if err != nil {
+	if err != nil && !(err == io.EOF && n == len(dest)) {
 		// Fall back to remote source if local cache file fails for some reason
 		return s3c.getContentFromSource(dest, start, end)
 	}
@@ -331,6 +472,20 @@ func (s3c *S3ClipStorage) ReadFile(node *common.ClipNode, dest []byte, off int64
 	return n, nil
 }
 
+// readCacheMmap copies from the memory mapped cache at start. It returns false if the
+// cache isn't mapped or doesn't cover start.
+func (s3c *S3ClipStorage) readCacheMmap(dest []byte, start int64) (int, bool) {
+	s3c.cacheMmapMu.RLock()
+	defer s3c.cacheMmapMu.RUnlock()
+
+	data := s3c.cacheMmap
+	if data == nil || start >= int64(len(data)) {
+		return 0, false
+	}
+
+	return copy(dest, data[start:]), true
+}
+
 func (s3c *S3ClipStorage) downloadChunk(start int64, end int64) ([]byte, error) {
 	rangeHeader := fmt.Sprintf("bytes=%d-%d", start, end)
 	getObjectInput := &s3.GetObjectInput{
@@ -339,17 +494,26 @@ func (s3c *S3ClipStorage) downloadChunk(start int64, end int64) ([]byte, error)
 		Range:  aws.String(rangeHeader),
 	}
 
+	// The read timeout only bounds chunk downloads. HeadBucket, HeadObject and the background
+	// download of the whole archive can legitimately take longer and aren't limited by it.
+	ctx := context.Background()
+	if s3c.readTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s3c.readTimeout)
+		defer cancel()
+	}
+
 	// Attempt to download chunk from S3
-	resp, err := s3c.svc.GetObject(context.Background(), getObjectInput)
+	resp, err := s3c.svc.GetObject(ctx, getObjectInput)
 	if err != nil {
-		return nil, err
+		return nil, wrapRemoteError(err)
 	}
 	defer resp.Body.Close()
 
 	buf := new(bytes.Buffer)
 	_, err = io.Copy(buf, resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, wrapRemoteError(err)
 	}
 
 	return buf.Bytes()[:buf.Len()], nil
@@ -360,6 +524,15 @@ func (s3c *S3ClipStorage) Metadata() *common.ClipArchiveMetadata {
 }
 
 func (s3c *S3ClipStorage) Cleanup() error {
+	s3c.cacheMmapMu.Lock()
+	defer s3c.cacheMmapMu.Unlock()
+
+	s3c.closed = true
+	if s3c.cacheMmap != nil {
+		unix.Munmap(s3c.cacheMmap)
+		s3c.cacheMmap = nil
+	}
+
 	if s3c.cacheFile != nil {
 		s3c.cacheFile.Close()
 	}
@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+func fileMode(t *testing.T, path string) os.FileMode {
+	t.Helper()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Mode().Perm()
+}
+
+func TestOpenCacheFileMode(t *testing.T) {
+	// Modes of created files must not depend on the umask
+	defer syscall.Umask(syscall.Umask(0077))
+
+	tests := []struct {
+		name     string
+		existing os.FileMode // Mode of a file already at the path, zero if there is none
+		mode     os.FileMode
+		force    bool
+		want     os.FileMode
+	}{
+		{name: "new file, default mode", mode: defaultCacheFileMode, want: 0644},
+		{name: "new file, configured mode", mode: 0640, force: true, want: 0640},
+		{name: "existing file, default mode", existing: 0600, mode: defaultCacheFileMode, want: 0600},
+		{name: "existing file, configured mode", existing: 0600, mode: 0640, force: true, want: 0640},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "archive.cache")
+			if tt.existing != 0 {
+				if err := os.WriteFile(path, []byte("cached"), tt.existing); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(path, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s3c := &S3ClipStorage{cacheFileMode: tt.mode, forceFileMode: tt.force}
+			f, err := s3c.openCacheFile(path, os.O_RDWR|os.O_CREATE)
+			if err != nil {
+				t.Fatalf("unable to open cache file: %v", err)
+			}
+			f.Close()
+
+			if got := fileMode(t, path); got != tt.want {
+				t.Fatalf("expected mode %o, got %o", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOpenCacheFileKeepsContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.cache")
+	if err := os.WriteFile(path, []byte("cached"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s3c := &S3ClipStorage{cacheFileMode: defaultCacheFileMode}
+	f, err := s3c.openCacheFile(path, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatalf("unable to open cache file: %v", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 16)
+	n, _ := f.Read(buf)
+	if got := string(buf[:n]); got != "cached" {
+		t.Fatalf("expected existing content to be kept, got %q", got)
+	}
+}
+
+// newTestS3Server serves testArchive as bucket/key to S3 HEADs and range GETs, and counts the GETs
+func newTestS3Server(t *testing.T) (*s3.Client, *int32) {
+	t.Helper()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.URL.Path != "/bucket/key" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&requests, 1)
+		}
+		http.ServeContent(w, r, "key", time.Time{}, bytes.NewReader(testArchive))
+	}))
+	t.Cleanup(server.Close)
+
+	svc := s3.New(s3.Options{
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	return svc, &requests
+}
+
+func newTestS3Storage(t *testing.T) (*S3ClipStorage, *int32) {
+	t.Helper()
+
+	svc, requests := newTestS3Server(t)
+	return &S3ClipStorage{svc: svc, bucket: "bucket", key: "key", readTimeout: 5 * time.Second}, requests
+}
+
+func openTestCacheFile(t *testing.T) *os.File {
+	t.Helper()
+
+	f, err := os.Open(writeTestArchiveFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestS3ReadFileClampedRemote(t *testing.T) {
+	s3c, requests := newTestS3Storage(t)
+
+	testClampedReads(t, s3c)
+
+	if atomic.LoadInt32(requests) == 0 {
+		t.Fatalf("expected reads to go to S3")
+	}
+}
+
+func TestS3ReadFileClampedCacheFile(t *testing.T) {
+	s3c, requests := newTestS3Storage(t)
+	s3c.cacheFile = openTestCacheFile(t)
+	s3c.cachedLocally = true
+	defer s3c.Cleanup()
+
+	testClampedReads(t, s3c)
+
+	// Reads up to the end of the cache file must not fall back to S3
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Fatalf("expected every read to be served from the cache file, %d went to S3", n)
+	}
+}
+
+func TestS3ReadFileClampedMmap(t *testing.T) {
+	s3c, requests := newTestS3Storage(t)
+	s3c.cacheFile = openTestCacheFile(t)
+	s3c.useMmapCache = true
+	s3c.cacheMmapMu.Lock()
+	s3c.mapCacheFile(int64(len(testArchive)))
+	s3c.cacheMmapMu.Unlock()
+	s3c.cachedLocally = true
+	defer s3c.Cleanup()
+
+	if s3c.cacheMmap == nil {
+		t.Fatalf("cache file was not mapped")
+	}
+
+	testClampedReads(t, s3c)
+
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Fatalf("expected every read to be served from the mapping, %d went to S3", n)
+	}
+}
+
+func TestS3CleanupBeforeCacheMapped(t *testing.T) {
+	for _, closed := range []bool{false, true} {
+		s3c, _ := newTestS3Storage(t)
+		s3c.cacheFile = openTestCacheFile(t)
+		s3c.localCachePath = s3c.cacheFile.Name()
+		s3c.useMmapCache = true
+
+		// As if Cleanup ran while the download was looking up the archive size
+		s3c.closed = closed
+
+		// The cache file already holds the whole archive, so this maps it straight away
+		s3c.startBackgroundDownload()
+
+		if mapped := s3c.cacheMmap != nil; mapped == closed {
+			t.Errorf("closed %v: expected mapped to be %v, got %v", closed, !closed, mapped)
+		}
+		if s3c.CachedLocally() == closed {
+			t.Errorf("closed %v: expected cached locally to be %v", closed, !closed)
+		}
+
+		s3c.Cleanup()
+		if s3c.cacheMmap != nil {
+			t.Errorf("closed %v: expected the mapping to be released", closed)
+		}
+	}
+}
+
+// BenchmarkS3ReadFileCached compares reading from the memory mapped cache with reading
+// through the cache file handle, for repeated small reads at random offsets
+func BenchmarkS3ReadFileCached(b *testing.B) {
+	const size = 1 << 26 // 64Mb
+	const readSize = 4096
+
+	path := filepath.Join(b.TempDir(), "archive.cache")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0x5a}, size), 0644); err != nil {
+		b.Fatal(err)
+	}
+	node := &common.ClipNode{Path: "/file", NodeType: common.FileNode, DataLen: size}
+
+	for _, mmap := range []bool{false, true} {
+		b.Run(fmt.Sprintf("mmap=%v", mmap), func(b *testing.B) {
+			f, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			s3c := &S3ClipStorage{cacheFile: f, cachedLocally: true, useMmapCache: mmap}
+			s3c.cacheMmapMu.Lock()
+			s3c.mapCacheFile(size)
+			s3c.cacheMmapMu.Unlock()
+			defer s3c.Cleanup()
+
+			rng := rand.New(rand.NewSource(1))
+			dest := make([]byte, readSize)
+
+			b.SetBytes(readSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s3c.ReadFile(node, dest, rng.Int63n(size-readSize)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestS3Upload(t *testing.T) {
+	tests := []struct {
+		name         string
+		sseMode      types.ServerSideEncryption
+		kmsKeyID     string
+		storageClass types.StorageClass
+	}{
+		{name: "defaults"},
+		{name: "AES256", sseMode: types.ServerSideEncryptionAes256, storageClass: types.StorageClassStandardIa},
+		{name: "KMS", sseMode: types.ServerSideEncryptionAwsKms, kmsKeyID: "key-id", storageClass: types.StorageClassIntelligentTiering},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header http.Header
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPut || r.URL.Path != "/bucket/key" {
+					http.Error(w, "unexpected request", http.StatusBadRequest)
+					return
+				}
+				header = r.Header.Clone()
+				body, _ = io.ReadAll(r.Body)
+			}))
+			defer server.Close()
+
+			svc := s3.New(s3.Options{
+				BaseEndpoint: aws.String(server.URL),
+				UsePathStyle: true,
+				Region:       "us-east-1",
+				Credentials:  aws.AnonymousCredentials{},
+			})
+			s3c := &S3ClipStorage{svc: svc, bucket: "bucket", key: "key", sseMode: tt.sseMode, kmsKeyID: tt.kmsKeyID, storageClass: tt.storageClass}
+
+			if err := s3c.Upload(context.Background(), writeTestArchiveFile(t), nil); err != nil {
+				t.Fatalf("upload failed: %v", err)
+			}
+
+			if !bytes.Contains(body, testArchive) {
+				t.Errorf("expected the archive to be uploaded, got %q", body)
+			}
+			for name, want := range map[string]string{
+				"X-Amz-Server-Side-Encryption":                string(tt.sseMode),
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": tt.kmsKeyID,
+				"X-Amz-Storage-Class":                         string(tt.storageClass),
+			} {
+				if got := header.Get(name); got != want {
+					t.Errorf("expected %s to be %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNewS3ClipStorageInvalidOpts(t *testing.T) {
+	tests := []struct {
+		name string
+		opts S3ClipStorageOpts
+	}{
+		{name: "unknown encryption mode", opts: S3ClipStorageOpts{SSEMode: "rot13"}},
+		{name: "lowercase encryption mode", opts: S3ClipStorageOpts{SSEMode: "aes256"}},
+		{name: "KMS key without KMS encryption", opts: S3ClipStorageOpts{SSEMode: "AES256", KMSKeyID: "key-id"}},
+		{name: "unknown storage class", opts: S3ClipStorageOpts{StorageClass: "COLD"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Options are validated before any request is made
+			if _, err := NewS3ClipStorage(nil, tt.opts); err == nil {
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+}
+
+func TestIsKnownValue(t *testing.T) {
+	known := types.ServerSideEncryption("").Values()
+	for _, v := range []types.ServerSideEncryption{types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms} {
+		if !isKnownValue(v, known) {
+			t.Errorf("expected %q to be known", v)
+		}
+	}
+	for _, v := range []types.ServerSideEncryption{"", "rot13", "aes256"} {
+		if isKnownValue(v, known) {
+			t.Errorf("expected %q to be unknown", v)
+		}
+	}
+}
+
+func TestS3DownloadChunkTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stall until the client gives up
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	svc := s3.New(s3.Options{
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	const timeout = 200 * time.Millisecond
+	s3c := &S3ClipStorage{svc: svc, bucket: "bucket", key: "key", readTimeout: timeout}
+
+	start := time.Now()
+	_, err := s3c.downloadChunk(0, 10)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, common.ErrStorageTimeout) {
+		t.Fatalf("expected ErrStorageTimeout, got %v", err)
+	}
+	if elapsed > timeout+time.Second {
+		t.Fatalf("expected the read to give up after %v, took %v", timeout, elapsed)
+	}
+}
@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/common"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/tidwall/btree"
+)
+
+// The service protocol is plain HTTP so thin clients need nothing beyond net/http:
+//
+//	GET /v1/index                                   gob-encoded serviceIndex
+//	GET /v1/stat?path=<path>                        JSON common.ClipNode
+//	GET /v1/readdir?path=<path>                     JSON []fuse.DirEntry
+//	GET /v1/read?path=<path>&offset=<n>&length=<n>  raw file content
+const (
+	serviceIndexPath   = "/v1/index"
+	serviceStatPath    = "/v1/stat"
+	serviceReaddirPath = "/v1/readdir"
+	serviceReadPath    = "/v1/read"
+
+	maxServiceReadLength = 1 << 24 // 16Mb
+
+	defaultServiceIndexTimeout = time.Minute * 10
+)
+
+// serviceIndex is the metadata of the served archive, as returned by /v1/index
+type serviceIndex struct {
+	Header      common.ClipArchiveHeader
+	StorageInfo *common.StorageInfoWrapper // Nil if the archive has no storage info
+	Nodes       []*common.ClipNode
+}
+
+// NewServiceHandler serves the metadata and file content of an archive to remote
+// ServiceClipStorage clients. If bearerToken is set, requests must present it.
+func NewServiceHandler(s ClipStorageInterface, bearerToken string) http.Handler {
+	h := &serviceHandler{storage: s, metadata: s.Metadata(), bearerToken: bearerToken}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(serviceIndexPath, h.index)
+	mux.HandleFunc(serviceStatPath, h.stat)
+	mux.HandleFunc(serviceReaddirPath, h.readdir)
+	mux.HandleFunc(serviceReadPath, h.read)
+
+	return h.authorize(mux)
+}
+
+type serviceHandler struct {
+	storage     ClipStorageInterface
+	metadata    *common.ClipArchiveMetadata
+	bearerToken string
+}
+
+func (h *serviceHandler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if h.bearerToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.bearerToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *serviceHandler) index(w http.ResponseWriter, r *http.Request) {
+	index := serviceIndex{Header: h.metadata.Header}
+	h.metadata.Index.Ascend(h.metadata.Index.Min(), func(a interface{}) bool {
+		index.Nodes = append(index.Nodes, a.(*common.ClipNode))
+		return true
+	})
+
+	if h.metadata.StorageInfo != nil {
+		data, err := h.metadata.StorageInfo.Encode()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error encoding storage info: %v", err), http.StatusInternalServerError)
+			return
+		}
+		index.StorageInfo = &common.StorageInfoWrapper{Type: h.metadata.StorageInfo.Type(), Data: data}
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := gob.NewEncoder(w).Encode(index); err != nil {
+		log.Printf("error encoding index: %v", err)
+	}
+}
+
+func (h *serviceHandler) stat(w http.ResponseWriter, r *http.Request) {
+	node := h.metadata.Get(r.URL.Query().Get("path"))
+	if node == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, node)
+}
+
+func (h *serviceHandler) readdir(w http.ResponseWriter, r *http.Request) {
+	node := h.metadata.Get(r.URL.Query().Get("path"))
+	if node == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !node.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, h.metadata.ListDirectory(node.Path))
+}
+
+func (h *serviceHandler) read(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	node := h.metadata.Get(query.Get("path"))
+	if node == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if node.NodeType != common.FileNode {
+		http.Error(w, "not a file", http.StatusBadRequest)
+		return
+	}
+
+	offset, err := strconv.ParseInt(query.Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(query.Get("length"), 10, 64)
+	if err != nil || length < 0 || length > maxServiceReadLength {
+		http.Error(w, "invalid length", http.StatusBadRequest)
+		return
+	}
+
+	// Never read past the end of the file
+	dest := clampRead(node, make([]byte, length), offset)
+	n, err := h.storage.ReadFile(node, dest, offset)
+	if err != nil {
+		http.Error(w, err.Error(), statusCodeFromError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	w.Write(dest[:n])
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding response: %v", err)
+	}
+}
+
+// statusCodeFromError is the inverse of errorFromStatusCode, so clients see the
+// same storage error the service did
+func statusCodeFromError(err error) int {
+	switch {
+	case errors.Is(err, common.ErrStorageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrStorageAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, common.ErrStorageTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type ServiceClipStorage struct {
+	address     string
+	bearerToken string
+	client      *http.Client
+	readTimeout time.Duration
+	metadata    *common.ClipArchiveMetadata
+}
+
+type ServiceClipStorageOpts struct {
+	Address      string // Base URL of the service, e.g. http://clip-daemon:8080
+	BearerToken  string
+	ReadTimeout  time.Duration // Timeout for a single request, defaults to 60s
+	IndexTimeout time.Duration // Timeout for fetching the index, which grows with the archive, defaults to 10m
+}
+
+// NewServiceClipStorage connects to an archive served by NewServiceHandler. The
+// index is fetched once up front, reads are forwarded to the service.
+func NewServiceClipStorage(opts ServiceClipStorageOpts) (*ServiceClipStorage, error) {
+	if opts.Address == "" {
+		return nil, fmt.Errorf("no address provided for service storage")
+	}
+
+	readTimeout := opts.ReadTimeout
+	if readTimeout <= 0 {
+		readTimeout = defaultHTTPReadTimeout
+	}
+
+	indexTimeout := opts.IndexTimeout
+	if indexTimeout <= 0 {
+		indexTimeout = defaultServiceIndexTimeout
+	}
+
+	// Timeouts are set per request, since the index takes far longer to fetch than a read
+	s := &ServiceClipStorage{
+		address:     strings.TrimSuffix(opts.Address, "/"),
+		bearerToken: opts.BearerToken,
+		client:      &http.Client{},
+		readTimeout: readTimeout,
+	}
+
+	metadata, err := s.fetchMetadata(indexTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch index from <%s>: %w", s.address, err)
+	}
+	s.metadata = metadata
+
+	return s, nil
+}
+
+func (s *ServiceClipStorage) fetchMetadata(timeout time.Duration) (*common.ClipArchiveMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := s.get(ctx, serviceIndexPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body serviceIndex
+	if err := gob.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding index: %w", wrapRemoteError(err))
+	}
+
+	index := btree.New(func(a, b interface{}) bool {
+		return a.(*common.ClipNode).Path < b.(*common.ClipNode).Path
+	})
+	for _, node := range body.Nodes {
+		index.Set(node)
+	}
+
+	metadata := &common.ClipArchiveMetadata{Index: index, Header: body.Header}
+	if body.StorageInfo != nil {
+		metadata.StorageInfo, err = common.DecodeStorageInfo(*body.StorageInfo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return metadata, nil
+}
+
+// get sends a request to the service and returns the response if it succeeded. The
+// caller must keep ctx alive until it's done with the response body.
+func (s *ServiceClipStorage) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := s.address + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearerToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, wrapRemoteError(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		err := fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		if classified := errorFromStatusCode(resp.StatusCode); classified != nil {
+			err = fmt.Errorf("%w: %v", classified, err)
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Stat returns the node at path without consulting the local copy of the index
+func (s *ServiceClipStorage) Stat(path string) (*common.ClipNode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.readTimeout)
+	defer cancel()
+
+	resp, err := s.get(ctx, serviceStatPath, url.Values{"path": {path}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var node common.ClipNode
+	if err := json.NewDecoder(resp.Body).Decode(&node); err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+// ReadDir lists the immediate children of the directory at path
+func (s *ServiceClipStorage) ReadDir(path string) ([]fuse.DirEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.readTimeout)
+	defer cancel()
+
+	resp, err := s.get(ctx, serviceReaddirPath, url.Values{"path": {path}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var entries []fuse.DirEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *ServiceClipStorage) ReadFile(node *common.ClipNode, dest []byte, off int64) (int, error) {
+	dest = clampRead(node, dest, off)
+
+	total := 0
+	for total < len(dest) {
+		chunk := dest[total:]
+		if len(chunk) > maxServiceReadLength {
+			chunk = chunk[:maxServiceReadLength]
+		}
+
+		n, err := s.readChunk(node, chunk, off+int64(total))
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+func (s *ServiceClipStorage) readChunk(node *common.ClipNode, dest []byte, off int64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.readTimeout)
+	defer cancel()
+
+	resp, err := s.get(ctx, serviceReadPath, url.Values{
+		"path":   {node.Path},
+		"offset": {strconv.FormatInt(off, 10)},
+		"length": {strconv.Itoa(len(dest))},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// dest is clamped to the end of the file, so a short body means the response was cut off
+	n, err := io.ReadFull(resp.Body, dest)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return n, fmt.Errorf("truncated response reading <%s> from <%s>: got %d of %d bytes: %w", node.Path, s.address, n, len(dest), io.ErrUnexpectedEOF)
+	}
+
+	return n, wrapRemoteError(err)
+}
+
+func (s *ServiceClipStorage) CachedLocally() bool {
+	return false
+}
+
+func (s *ServiceClipStorage) Metadata() *common.ClipArchiveMetadata {
+	return s.metadata
+}
+
+func (s *ServiceClipStorage) Cleanup() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
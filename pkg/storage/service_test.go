@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/beam-cloud/clip/pkg/common"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/tidwall/btree"
+)
+
+// memClipStorage serves the content of a single file from memory
+type memClipStorage struct {
+	metadata *common.ClipArchiveMetadata
+	content  []byte
+}
+
+func newMemClipStorage(content []byte) *memClipStorage {
+	index := btree.New(func(a, b interface{}) bool {
+		return a.(*common.ClipNode).Path < b.(*common.ClipNode).Path
+	})
+	index.Set(&common.ClipNode{Path: "/", NodeType: common.DirNode, Attr: fuse.Attr{Mode: fuse.S_IFDIR | 0755}})
+	index.Set(&common.ClipNode{Path: "/file", NodeType: common.FileNode, DataLen: int64(len(content)), Attr: fuse.Attr{Mode: fuse.S_IFREG | 0644}})
+
+	return &memClipStorage{metadata: &common.ClipArchiveMetadata{Index: index}, content: content}
+}
+
+func (s *memClipStorage) ReadFile(node *common.ClipNode, dest []byte, off int64) (int, error) {
+	return copy(clampRead(node, dest, off), s.content[off:]), nil
+}
+
+func (s *memClipStorage) Metadata() *common.ClipArchiveMetadata { return s.metadata }
+func (s *memClipStorage) CachedLocally() bool                   { return true }
+func (s *memClipStorage) Cleanup() error                        { return nil }
+
+func newTestServiceStorage(t *testing.T, handler http.Handler, token string) (*ServiceClipStorage, error) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	s, err := NewServiceClipStorage(ServiceClipStorageOpts{Address: server.URL, BearerToken: token})
+	if err == nil {
+		t.Cleanup(func() { s.Cleanup() })
+	}
+
+	return s, err
+}
+
+func TestServiceReadFile(t *testing.T) {
+	s, err := newTestServiceStorage(t, NewServiceHandler(newMemClipStorage([]byte("hello world")), "secret"), "secret")
+	if err != nil {
+		t.Fatalf("unable to connect to service: %v", err)
+	}
+
+	node := s.Metadata().Get("/file")
+	if node == nil {
+		t.Fatalf("index is missing /file")
+	}
+
+	// Reads past the end of the file are clamped
+	dest := make([]byte, 16)
+	n, err := s.ReadFile(node, dest, 6)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got := string(dest[:n]); got != "world" {
+		t.Fatalf("expected %q, got %q", "world", got)
+	}
+}
+
+func TestServiceUnauthorized(t *testing.T) {
+	handler := NewServiceHandler(newMemClipStorage([]byte("hello world")), "secret")
+
+	for _, token := range []string{"", "wrong", "secret2"} {
+		if _, err := newTestServiceStorage(t, handler, token); !errors.Is(err, common.ErrStorageAccessDenied) {
+			t.Errorf("token %q: expected ErrStorageAccessDenied, got %v", token, err)
+		}
+	}
+}
+
+func TestServiceReadFileTruncatedResponse(t *testing.T) {
+	service := NewServiceHandler(newMemClipStorage([]byte("hello world")), "")
+
+	// Promise the whole read but drop the connection part way through the body
+	mux := http.NewServeMux()
+	mux.Handle(serviceIndexPath, service)
+	mux.HandleFunc(serviceReadPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(11))
+		w.Write([]byte("hello"))
+	})
+
+	s, err := newTestServiceStorage(t, mux, "")
+	if err != nil {
+		t.Fatalf("unable to connect to service: %v", err)
+	}
+
+	n, err := s.ReadFile(s.Metadata().Get("/file"), make([]byte, 11), 0)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected unexpected EOF, got n=%d err=%v", n, err)
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 bytes before the truncation, got %d", n)
+	}
+}
+
+func TestStatusCodeFromError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+		back error // What the client maps the status back to
+	}{
+		{err: common.ErrStorageNotFound, want: http.StatusNotFound, back: common.ErrStorageNotFound},
+		{err: fmt.Errorf("%w: missing", common.ErrStorageNotFound), want: http.StatusNotFound, back: common.ErrStorageNotFound},
+		{err: common.ErrStorageAccessDenied, want: http.StatusForbidden, back: common.ErrStorageAccessDenied},
+		{err: common.ErrStorageTimeout, want: http.StatusGatewayTimeout, back: common.ErrStorageTimeout},
+		{err: errors.New("disk failure"), want: http.StatusInternalServerError, back: nil},
+	}
+
+	for _, tt := range tests {
+		code := statusCodeFromError(tt.err)
+		if code != tt.want {
+			t.Errorf("%v: expected status %d, got %d", tt.err, tt.want, code)
+		}
+		if got := errorFromStatusCode(code); got != tt.back {
+			t.Errorf("%v: expected status %d to map back to %v, got %v", tt.err, code, tt.back, got)
+		}
+	}
+}
+
+func TestServiceMetadata(t *testing.T) {
+	header := common.ClipArchiveHeader{
+		ClipFileFormatVersion: common.ClipFileFormatVersion,
+		IndexLength:           100,
+		IndexPos:              78,
+		StorageInfoLength:     42,
+		StorageInfoPos:        178,
+		MetadataChecksum:      0x1234,
+	}
+	copy(header.StartBytes[:], common.ClipFileStartBytes)
+	copy(header.StorageInfoType[:], "s3")
+
+	tests := []struct {
+		name        string
+		header      common.ClipArchiveHeader
+		storageInfo common.ClipStorageInfo
+	}{
+		{name: "local"},
+		{name: "s3", header: header, storageInfo: common.S3StorageInfo{Bucket: "bucket", Region: "us-east-1", Key: "key", StorageClass: "STANDARD_IA"}},
+		{name: "http", header: header, storageInfo: common.HTTPStorageInfo{URL: "https://example.com/test.clip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := newMemClipStorage([]byte("hello world"))
+			served.metadata.Header = tt.header
+			served.metadata.StorageInfo = tt.storageInfo
+
+			s, err := newTestServiceStorage(t, NewServiceHandler(served, ""), "")
+			if err != nil {
+				t.Fatalf("unable to connect to service: %v", err)
+			}
+
+			metadata := s.Metadata()
+			if metadata.Header != tt.header {
+				t.Errorf("expected header %+v, got %+v", tt.header, metadata.Header)
+			}
+			if metadata.StorageInfo != tt.storageInfo {
+				t.Errorf("expected storage info %+v, got %+v", tt.storageInfo, metadata.StorageInfo)
+			}
+			if metadata.Get("/file") == nil {
+				t.Errorf("index is missing /file")
+			}
+		})
+	}
+}
+
+func TestServiceIndexTimeout(t *testing.T) {
+	handler := NewServiceHandler(newMemClipStorage([]byte("hello world")), "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A large index takes longer to send than a read
+		if r.URL.Path == serviceIndexPath {
+			time.Sleep(300 * time.Millisecond)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	s, err := NewServiceClipStorage(ServiceClipStorageOpts{Address: server.URL, ReadTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("expected the index fetch to outlast the read timeout, got %v", err)
+	}
+	s.Cleanup()
+
+	_, err = NewServiceClipStorage(ServiceClipStorageOpts{Address: server.URL, IndexTimeout: 100 * time.Millisecond})
+	if !errors.Is(err, common.ErrStorageTimeout) {
+		t.Fatalf("expected ErrStorageTimeout, got %v", err)
+	}
+}
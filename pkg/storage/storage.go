@@ -2,6 +2,8 @@ package storage
 
 import (
 	"errors"
+	"os"
+	"time"
 
 	"github.com/beam-cloud/clip/pkg/common"
 )
@@ -13,15 +15,50 @@ type ClipStorageInterface interface {
 	Cleanup() error
 }
 
+// clampRead limits a read at off to the end of the node's data, so reads spanning the end of
+// a file are short instead of returning bytes that belong to whatever follows it
+func clampRead(node *common.ClipNode, dest []byte, off int64) []byte {
+	if off >= node.DataLen {
+		return dest[:0]
+	}
+	if remaining := node.DataLen - off; int64(len(dest)) > remaining {
+		return dest[:remaining]
+	}
+	return dest
+}
+
 type ClipStorageCredentials struct {
-	S3 *S3ClipStorageCredentials
+	S3   *S3ClipStorageCredentials
+	HTTP *HTTPClipStorageCredentials
+}
+
+type ClipStorageOpts struct {
+	ArchivePath   string
+	CachePath     string
+	CacheFileMode os.FileMode
+	UseMmapCache  bool
+	ReadTimeout   time.Duration // Zero means no timeout for S3, and 60s for HTTP storage
+	Metadata      *common.ClipArchiveMetadata
+	Credentials   ClipStorageCredentials
 }
 
+// NewClipStorage creates the storage for an archive with default options
 func NewClipStorage(archivePath string, cachePath string, metadata *common.ClipArchiveMetadata, credentials ClipStorageCredentials) (ClipStorageInterface, error) {
+	return NewClipStorageWithOpts(ClipStorageOpts{
+		ArchivePath: archivePath,
+		CachePath:   cachePath,
+		Metadata:    metadata,
+		Credentials: credentials,
+	})
+}
+
+func NewClipStorageWithOpts(opts ClipStorageOpts) (ClipStorageInterface, error) {
 	var storage ClipStorageInterface = nil
 	var storageType string
 	var err error = nil
 
+	metadata := opts.Metadata
+	credentials := opts.Credentials
 	header := metadata.Header
 
 	// This a remote archive, so we have to load that particular storage implementation
@@ -34,21 +71,37 @@ func NewClipStorage(archivePath string, cachePath string, metadata *common.ClipA
 	switch storageType {
 	case "s3":
 		storageInfo := metadata.StorageInfo.(common.S3StorageInfo)
-		opts := S3ClipStorageOpts{
-			Bucket:    storageInfo.Bucket,
-			Region:    storageInfo.Region,
-			Key:       storageInfo.Key,
-			Endpoint:  storageInfo.Endpoint,
-			CachePath: cachePath,
-			AccessKey: credentials.S3.AccessKey,
-			SecretKey: credentials.S3.SecretKey,
+		s3Opts := S3ClipStorageOpts{
+			Bucket:                storageInfo.Bucket,
+			Region:                storageInfo.Region,
+			Key:                   storageInfo.Key,
+			Endpoint:              storageInfo.Endpoint,
+			CachePath:             opts.CachePath,
+			CacheFileMode:         opts.CacheFileMode,
+			UseMmapCache:          opts.UseMmapCache,
+			ReadTimeout:           opts.ReadTimeout,
+			UseDefaultCredentials: storageInfo.UseDefaultCredentials,
+		}
+		if credentials.S3 != nil {
+			s3Opts.AccessKey = credentials.S3.AccessKey
+			s3Opts.SecretKey = credentials.S3.SecretKey
+		}
+		storage, err = NewS3ClipStorage(metadata, s3Opts)
+	case "http":
+		storageInfo := metadata.StorageInfo.(common.HTTPStorageInfo)
+		httpOpts := HTTPClipStorageOpts{
+			URL:         storageInfo.URL,
+			ReadTimeout: opts.ReadTimeout,
+		}
+		if credentials.HTTP != nil {
+			httpOpts.BearerToken = credentials.HTTP.BearerToken
 		}
-		storage, err = NewS3ClipStorage(metadata, opts)
+		storage, err = NewHTTPClipStorage(metadata, httpOpts)
 	case "local":
-		opts := LocalClipStorageOpts{
-			ArchivePath: archivePath,
+		localOpts := LocalClipStorageOpts{
+			ArchivePath: opts.ArchivePath,
 		}
-		storage, err = NewLocalClipStorage(metadata, opts)
+		storage, err = NewLocalClipStorage(metadata, localOpts)
 	default:
 		err = errors.New("unsupported storage type")
 	}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/beam-cloud/clip/pkg/common"
+)
+
+// testArchive holds two files back to back, and the last one ends the archive, so reads
+// that aren't clamped either bleed into the next file or hit the end of the archive
+var (
+	testArchive   = []byte("header" + "hello" + "world!")
+	testFirstNode = &common.ClipNode{Path: "/first", NodeType: common.FileNode, DataPos: 6, DataLen: 5}
+	testLastNode  = &common.ClipNode{Path: "/last", NodeType: common.FileNode, DataPos: 11, DataLen: 6}
+)
+
+var clampReadTests = []struct {
+	name   string
+	node   *common.ClipNode
+	off    int64
+	length int
+	want   string
+}{
+	{name: "whole file", node: testFirstNode, off: 0, length: 5, want: "hello"},
+	{name: "past the end of a file", node: testFirstNode, off: 2, length: 100, want: "llo"},
+	{name: "whole last file", node: testLastNode, off: 0, length: 6, want: "world!"},
+	{name: "last bytes of the last file", node: testLastNode, off: 3, length: 3, want: "ld!"},
+	{name: "past the end of the last file", node: testLastNode, off: 3, length: 100, want: "ld!"},
+	{name: "at the end of a file", node: testFirstNode, off: 5, length: 10, want: ""},
+	{name: "beyond the end of the last file", node: testLastNode, off: 100, length: 10, want: ""},
+}
+
+// testClampedReads checks that reads through s never return bytes beyond the end of a file
+func testClampedReads(t *testing.T, s ClipStorageInterface) {
+	t.Helper()
+
+	for _, tt := range clampReadTests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := make([]byte, tt.length)
+			n, err := s.ReadFile(tt.node, dest, tt.off)
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			if got := string(dest[:n]); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func writeTestArchiveFile(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.clip")
+	if err := os.WriteFile(path, testArchive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClampRead(t *testing.T) {
+	for _, tt := range clampReadTests {
+		if got := len(clampRead(tt.node, make([]byte, tt.length), tt.off)); got != len(tt.want) {
+			t.Errorf("%s: expected %d bytes, got %d", tt.name, len(tt.want), got)
+		}
+	}
+}
+
+func TestLocalReadFileClamped(t *testing.T) {
+	s, err := NewLocalClipStorage(nil, LocalClipStorageOpts{ArchivePath: writeTestArchiveFile(t)})
+	if err != nil {
+		t.Fatalf("unable to create local storage: %v", err)
+	}
+	defer s.Cleanup()
+
+	testClampedReads(t, s)
+}
+
+func TestNewClipStorageLocal(t *testing.T) {
+	metadata := &common.ClipArchiveMetadata{}
+	s, err := NewClipStorage(writeTestArchiveFile(t), "", metadata, ClipStorageCredentials{})
+	if err != nil {
+		t.Fatalf("unable to create storage: %v", err)
+	}
+	defer s.Cleanup()
+
+	if _, ok := s.(*LocalClipStorage); !ok {
+		t.Fatalf("expected local storage, got %T", s)
+	}
+	testClampedReads(t, s)
+}